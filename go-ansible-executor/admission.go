@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// maxPriority bounds request priorities to 0..maxPriority (higher runs first).
+const maxPriority = 9
+
+// admission hands out a fixed number of playbook run slots. When all slots are
+// taken, waiters are served highest priority first and FIFO within a priority.
+type admission struct {
+	mu      sync.Mutex
+	free    int
+	waiting [maxPriority + 1][]chan struct{}
+}
+
+// runSlots bounds concurrent runPlaybook executions across all handlers.
+var runSlots *admission
+
+func newAdmission(slots int) *admission {
+	if slots < 1 {
+		slots = 1
+	}
+	return &admission{free: slots}
+}
+
+// effectivePriority clamps a requested priority into the supported range.
+func effectivePriority(p int) int {
+	return min(max(p, 0), maxPriority)
+}
+
+// acquire blocks until a slot is granted or ctx is done.
+func (a *admission) acquire(ctx context.Context, prio int) error {
+	prio = effectivePriority(prio)
+
+	a.mu.Lock()
+	if a.free > 0 && !a.hasWaiters() {
+		a.free--
+		a.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	a.waiting[prio] = append(a.waiting[prio], ch)
+	a.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		queued := a.dequeue(prio, ch)
+		a.mu.Unlock()
+		if !queued {
+			// release() handed us the slot concurrently; pass it on
+			a.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release returns a slot, handing it directly to the next waiter if any.
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for p := maxPriority; p >= 0; p-- {
+		if q := a.waiting[p]; len(q) > 0 {
+			a.waiting[p] = q[1:]
+			close(q[0])
+			return
+		}
+	}
+	a.free++
+}
+
+// do runs fn while holding a slot, so ad-hoc ansible runs (ping, facts, syntax
+// checks, verification) count against MAX_CONCURRENT_RUNS like playbook runs.
+// fn isn't called if ctx is done before a slot is granted.
+func (a *admission) do(ctx context.Context, prio int, fn func()) error {
+	if err := a.acquire(ctx, prio); err != nil {
+		return err
+	}
+	defer a.release()
+	fn()
+	return nil
+}
+
+// queued is how many acquire calls are waiting for a slot.
+func (a *admission) queued() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, q := range a.waiting {
+		n += len(q)
+	}
+	return n
+}
+
+func (a *admission) hasWaiters() bool {
+	for _, q := range a.waiting {
+		if len(q) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dequeue removes ch from the wait list, reporting whether it was still queued.
+// Callers must hold a.mu.
+func (a *admission) dequeue(prio int, ch chan struct{}) bool {
+	q := a.waiting[prio]
+	for i, c := range q {
+		if c == ch {
+			a.waiting[prio] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
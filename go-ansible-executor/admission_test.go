@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionDo(t *testing.T) {
+	tests := []struct {
+		name    string
+		busy    bool // every slot is taken
+		wantRan bool
+		wantErr error
+	}{
+		{name: "free slot", wantRan: true},
+		{name: "no slot before ctx is done", busy: true, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdmission(1)
+			if tt.busy {
+				a.acquire(context.Background(), 0)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			ran, heldDuring := false, false
+			err := a.do(ctx, 0, func() {
+				ran = true
+				// the slot is ours while fn runs
+				probe, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				heldDuring = a.acquire(probe, 0) != nil
+			})
+			if !errors.Is(err, tt.wantErr) || ran != tt.wantRan {
+				t.Fatalf("do() = %v, ran %v; want %v, ran %v", err, ran, tt.wantErr, tt.wantRan)
+			}
+			if ran && !heldDuring {
+				t.Error("fn ran without holding a slot")
+			}
+			if !tt.busy {
+				// and it is given back afterwards
+				if err := a.acquire(ctx, 0); err != nil {
+					t.Errorf("slot not released: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionRe finds a dotted version, e.g. "2.15.3" in "ansible-playbook [core 2.15.3]"
+// (ansible-core) or "2.15" in a MIN_ANSIBLE_VERSION.
+var versionRe = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// checkAnsibleVersion makes sure bin can be run and reports at least version min,
+// so a missing or too old ansible fails at startup instead of on the first request.
+// It returns the version found.
+func checkAnsibleVersion(bin, min string) (string, error) {
+	want, ok := parseVersion(min)
+	if !ok {
+		return "", fmt.Errorf("invalid MIN_ANSIBLE_VERSION %q (want e.g. 2.15)", min)
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("%s not found (set ANSIBLE_PLAYBOOK_BIN or PATH): %w", bin, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %w", path, err)
+	}
+	first, _, _ := strings.Cut(string(out), "\n")
+	got, ok := parseVersion(first)
+	if !ok {
+		return "", fmt.Errorf("no version in %s --version output %q", path, first)
+	}
+	version := versionRe.FindString(first)
+	for i := range want {
+		if got[i] != want[i] {
+			if got[i] < want[i] {
+				return version, fmt.Errorf("%s is ansible %s, need at least %s (MIN_ANSIBLE_VERSION)", path, version, min)
+			}
+			break
+		}
+	}
+	return version, nil
+}
+
+// parseVersion extracts major, minor and patch (0 if absent) from the first version in s.
+func parseVersion(s string) (v [3]int, ok bool) {
+	m := versionRe.FindStringSubmatch(s)
+	if m == nil {
+		return v, false
+	}
+	for i, part := range m[1:] {
+		v[i], _ = strconv.Atoi(part) // "" for a missing patch gives 0
+	}
+	return v, true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAnsibleVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string // first line of --version; "" for no binary at all
+		min         string
+		wantVersion string
+		wantErr     string
+	}{
+		{name: "newer", output: "ansible-playbook [core 2.16.1]", min: "2.15", wantVersion: "2.16.1"},
+		{name: "exactly min", output: "ansible-playbook [core 2.15.0]", min: "2.15", wantVersion: "2.15.0"},
+		{name: "older patch", output: "ansible-playbook [core 2.15.2]", min: "2.15.3", wantVersion: "2.15.2", wantErr: "need at least 2.15.3"},
+		{name: "older minor", output: "ansible-playbook [core 2.14.9]", min: "2.15", wantVersion: "2.14.9", wantErr: "is ansible 2.14.9"},
+		{name: "old ansible", output: "ansible-playbook 2.9.27", min: "2.15", wantVersion: "2.9.27", wantErr: "need at least 2.15"},
+		{name: "no version", output: "ansible-playbook", min: "2.15", wantErr: "no version in"},
+		{name: "missing", min: "2.15", wantErr: "not found"},
+		{name: "bad min", output: "ansible-playbook [core 2.16.1]", min: "latest", wantErr: `invalid MIN_ANSIBLE_VERSION "latest"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := filepath.Join(t.TempDir(), "ansible-playbook")
+			if tt.output != "" {
+				script := "#!/bin/sh\necho '" + tt.output + "'\necho '  config file = None'\n"
+				if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			version, err := checkAnsibleVersion(bin, tt.min)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", version, tt.wantVersion)
+			}
+		})
+	}
+}
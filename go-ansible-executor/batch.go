@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item statuses of a batch request (see InstallRequest.Batch).
+const (
+	itemCompleted = "completed"
+	itemFailed    = "failed"
+	itemCancelled = "cancelled" // was running when the batch was cancelled
+	itemSkipped   = "skipped"   // hadn't started when the batch was cancelled or stopped
+
+	itemNotAttempted = "not_attempted" // left alone after the canary failed
+)
+
+// BatchItem is the outcome of one host of a batch request.
+type BatchItem struct {
+	Host            string `json:"host"`
+	Canary          bool   `json:"canary,omitempty"`
+	Status          string `json:"status"`
+	AnsibleExitCode int    `json:"ansible_exit_code,omitempty"`
+	Error           string `json:"error,omitempty"`
+	Category        string `json:"category,omitempty"`
+	DurationMs      int64  `json:"duration_ms,omitempty"`
+}
+
+// BatchCounts counts the items of a batch by status.
+type BatchCounts struct {
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+	Skipped   int `json:"skipped"`
+
+	NotAttempted int `json:"not_attempted,omitempty"`
+}
+
+func (c *BatchCounts) add(status string) {
+	switch status {
+	case itemCompleted:
+		c.Completed++
+	case itemFailed:
+		c.Failed++
+	case itemCancelled:
+		c.Cancelled++
+	case itemSkipped:
+		c.Skipped++
+	case itemNotAttempted:
+		c.NotAttempted++
+	}
+}
+
+// runBatch installs a batch request one host at a time, each with its own
+// inventory, and returns the final status. Once ctx is done the running host is
+// stopped and the hosts after it are skipped, without an inventory ever being
+// written for them. With canary_first the first host goes alone: the others run
+// only if it completes, and are not attempted if it fails. With parallel_hosts
+// the hosts (after the canary) all start at once and wait for run slots like
+// any other run; the items are still reported in host order.
+func (ir installRun) runBatch(ctx context.Context) InstallStatus {
+	req := ir.req
+	st := InstallStatus{
+		ID:       req.ID,
+		Name:     req.Name,
+		Status:   "success",
+		Priority: ir.priority,
+		Strategy: req.Strategy,
+		Serial:   req.Serial,
+	}
+	targets := req.targets()
+	items := make([]BatchItem, len(targets))
+	itemResults := make([][]playResult, len(targets))
+	next := 0 // first host not run yet
+	if req.CanaryFirst {
+		items[0], itemResults[0] = ir.runBatchItem(ctx, 0, targets[0])
+		items[0].Canary = true
+		next = 1
+		ir.publish(InstallStatus{
+			ID:        req.ID,
+			Name:      req.Name,
+			Stage:     stageCanary,
+			Status:    stageCanary,
+			Batch:     []BatchItem{items[0]},
+			Timestamp: time.Now(),
+		})
+		if items[0].Status == itemFailed {
+			for i := next; i < len(targets); i++ {
+				items[i] = BatchItem{Host: targets[i].IPAddress, Status: itemNotAttempted}
+			}
+			next = len(targets)
+		}
+	}
+	if req.ParallelHosts {
+		var wg sync.WaitGroup
+		for i := next; i < len(targets); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items[i], itemResults[i] = ir.runBatchItem(ctx, i, targets[i])
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := next; i < len(targets); i++ {
+			items[i], itemResults[i] = ir.runBatchItem(ctx, i, targets[i])
+		}
+	}
+
+	counts := &BatchCounts{}
+	var (
+		outputs []string
+		ran     []playResult
+		failed  = -1 // index of the first failed item
+	)
+	for i, h := range targets {
+		item := items[i]
+		st.Batch = append(st.Batch, item)
+		counts.add(item.Status)
+		if item.Status == itemFailed && failed < 0 {
+			failed = i
+		}
+		if len(itemResults[i]) > 0 {
+			outputs = append(outputs, fmt.Sprintf("===== %s =====\n%s", h.IPAddress, combinedOutput(itemResults[i])))
+			ran = append(ran, itemResults[i]...)
+		}
+	}
+	st.BatchCounts = counts
+	st.StartedAt, st.DurationMs = runSpan(ran)
+	st.AnsibleOutput = truncate(strings.Join(outputs, "\n"), cfg.MaxOutputBytes)
+
+	switch {
+	case errors.Is(context.Cause(ctx), errCancelled):
+		st.Status, st.Category = "error", catCancelled
+		st.Error = fmt.Sprintf("batch cancelled: %d completed, %d failed, %d cancelled, %d skipped",
+			counts.Completed, counts.Failed, counts.Cancelled, counts.Skipped)
+	case failed >= 0:
+		item := st.Batch[failed]
+		st.Status, st.AnsibleExitCode, st.Category = "error", item.AnsibleExitCode, item.Category
+		st.Error = fmt.Sprintf("%s: %s (%d of %d hosts failed)", item.Host, item.Error, counts.Failed, len(st.Batch))
+		if item.Canary {
+			st.Error = fmt.Sprintf("canary %s failed: %s (%d hosts not attempted)", item.Host, item.Error, counts.NotAttempted)
+		}
+	case counts.Completed < len(st.Batch):
+		// e.g. shut down between two hosts
+		st.Status, st.Category = "error", catInternal
+		st.Error = fmt.Sprintf("batch stopped: %d completed, %d skipped", counts.Completed, counts.Skipped)
+	}
+	st.Timestamp = time.Now()
+	return st
+}
+
+// runBatchItem runs every db_type of the request against the i-th host alone,
+// with an inventory of its own that is removed before it returns. results is
+// empty if no playbook ran.
+func (ir installRun) runBatchItem(ctx context.Context, i int, h HostSpec) (item BatchItem, results []playResult) {
+	item.Host = h.IPAddress
+	if ctx.Err() != nil {
+		item.Status = itemSkipped
+		return item, nil
+	}
+	req := ir.req.forHost(h)
+	ir.req = req
+	ir.runID = fmt.Sprintf("%s-%d", ir.runID, i)
+
+	invPath, err := writeInventory(req, ir.runID)
+	if err != nil {
+		slog.Error("write inventory failed", "id", req.ID, "host", i, "err", err)
+		item.Status, item.Error, item.Category = itemFailed, err.Error(), catInternal
+		return item, nil
+	}
+	defer removeInventory(invPath)
+	ir.invPath = invPath
+
+	if cfg.PreflightPing {
+		if st := pingFailure(req, invPath, pingHost(ctx, req, invPath)); st.Status != "" {
+			slog.Warn("ansible ping failed", "id", req.ID, "host", i, "addr", req.sshAddress(), "exit_code", st.AnsibleExitCode, "category", st.Category)
+			item.Status, item.Error, item.Category = batchItemStatus(st), st.Error, st.Category
+			item.AnsibleExitCode = st.AnsibleExitCode
+			return item, nil
+		}
+	}
+
+	types := req.dbTypes()
+	for _, t := range types {
+		results = append(results, ir.runDBType(ctx, t))
+	}
+	if ctx.Err() != nil && !slices.ContainsFunc(results, func(r playResult) bool { return !r.Started.IsZero() }) {
+		// cancelled while waiting for a run slot, e.g. behind the parallel others
+		item.Status = itemSkipped
+		return item, nil
+	}
+	var st InstallStatus
+	applyResults(&st, req, results)
+	if req.Verify && st.Status == "success" {
+		ir.verify(ctx, &st, types)
+	}
+	item.Status = batchItemStatus(st)
+	item.AnsibleExitCode, item.Error, item.Category = st.AnsibleExitCode, st.Error, st.Category
+	item.DurationMs = st.DurationMs
+	if item.Status == itemFailed && item.Error == "" {
+		item.Error = fmt.Sprintf("exit %d", item.AnsibleExitCode)
+	}
+	return item, results
+}
+
+// batchItemStatus is the item status for a host's final status.
+func batchItemStatus(st InstallStatus) string {
+	switch {
+	case st.Status == "success":
+		return itemCompleted
+	case st.Category == catCancelled:
+		return itemCancelled
+	}
+	return itemFailed
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRunBatchCancelled(t *testing.T) {
+	setupWorker(t)
+	req := testRequest()
+	req.IPAddress = ""
+	req.Batch = true
+	req.Hosts = []HostSpec{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2"}}
+	if err := validateRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, done := trackRun(context.Background(), req.ID, "run1")
+	defer done()
+	time.AfterFunc(100*time.Millisecond, func() {
+		if n := cancelRuns(req.ID); n != 1 {
+			t.Errorf("cancelRuns() = %d, want 1", n)
+		}
+	})
+	ir := installRun{req: req, runID: "run1", publish: func(InstallStatus) {}, runner: &fakeRunner{results: map[string]fakeRun{"10.0.0.1": {hang: true}}}}
+	st := ir.runBatch(ctx)
+
+	if st.Status != "error" || st.Category != catCancelled {
+		t.Errorf("status = %q, category %q, want error, %s", st.Status, st.Category, catCancelled)
+	}
+	if want := "batch cancelled: 0 completed, 0 failed, 1 cancelled, 1 skipped"; st.Error != want {
+		t.Errorf("error = %q, want %q", st.Error, want)
+	}
+	var items []string
+	for _, item := range st.Batch {
+		items = append(items, item.Status)
+	}
+	if want := []string{itemCancelled, itemSkipped}; !slices.Equal(items, want) {
+		t.Errorf("items = %v, want %v", items, want)
+	}
+	if want := (BatchCounts{Cancelled: 1, Skipped: 1}); st.BatchCounts == nil || *st.BatchCounts != want {
+		t.Errorf("batch_counts = %+v, want %+v", st.BatchCounts, want)
+	}
+	if left, _ := os.ReadDir(cfg.InventoryDir); len(left) != 0 {
+		t.Errorf("inventories left behind: %v", left)
+	}
+	if n := cancelRuns(req.ID + 1); n != 0 {
+		t.Errorf("cancelRuns() cancelled %d runs of an unknown id", n)
+	}
+}
+
+// batchRequest is testRequest over three hosts.
+func batchRequest() InstallRequest {
+	req := testRequest()
+	req.IPAddress, req.Batch = "", true
+	req.Hosts = []HostSpec{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2"}, {IPAddress: "10.0.0.3"}}
+	return req
+}
+
+func TestRunBatchCanaryFirst(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       []string
+		parallel   bool
+		wantStatus string
+		wantItems  []string
+		wantRuns   []string
+		wantError  string
+	}{
+		{
+			name:       "canary succeeds",
+			wantStatus: "success",
+			wantItems:  []string{itemCompleted, itemCompleted, itemCompleted},
+			wantRuns:   []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:       "canary succeeds, rest in parallel",
+			parallel:   true,
+			fail:       []string{"10.0.0.3"},
+			wantStatus: "error",
+			wantItems:  []string{itemCompleted, itemCompleted, itemFailed},
+			wantRuns:   []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			wantError:  "10.0.0.3: exit 2 (1 of 3 hosts failed)",
+		},
+		{
+			name:       "canary fails",
+			fail:       []string{"10.0.0.1"},
+			wantStatus: "error",
+			wantItems:  []string{itemFailed, itemNotAttempted, itemNotAttempted},
+			wantRuns:   []string{"10.0.0.1"},
+			wantError:  "canary 10.0.0.1 failed: exit 2 (2 hosts not attempted)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			runner := &fakeRunner{results: map[string]fakeRun{}}
+			for _, h := range tt.fail {
+				runner.results[h] = fakeRun{code: 2}
+			}
+			req := batchRequest()
+			req.Batch, req.CanaryFirst, req.ParallelHosts = false, true, tt.parallel
+			if err := validateRequest(req); err != nil {
+				t.Fatal(err)
+			}
+			var canary []InstallStatus
+			ir := installRun{req: req, runID: "run1", runner: runner, publish: func(st InstallStatus) {
+				if st.Stage == stageCanary {
+					canary = append(canary, st)
+				}
+			}}
+
+			st := ir.runBatch(context.Background())
+
+			if st.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (error %q)", st.Status, tt.wantStatus, st.Error)
+			}
+			if st.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", st.Error, tt.wantError)
+			}
+			wantCategory := ""
+			if tt.wantError != "" {
+				wantCategory = catPlaybook
+			}
+			if st.Category != wantCategory {
+				t.Errorf("category = %q, want %q", st.Category, wantCategory)
+			}
+			var items []string
+			for _, item := range st.Batch {
+				items = append(items, item.Status)
+			}
+			if !slices.Equal(items, tt.wantItems) {
+				t.Errorf("items = %v, want %v", items, tt.wantItems)
+			}
+			if !st.Batch[0].Canary || st.Batch[1].Canary {
+				t.Errorf("only the first item should be the canary: %+v", st.Batch)
+			}
+			got := runner.runs()
+			slices.Sort(got[1:]) // the hosts after the canary may run in any order
+			if !slices.Equal(got, tt.wantRuns) {
+				t.Errorf("ran %v, want %v", got, tt.wantRuns)
+			}
+			if len(canary) != 1 || len(canary[0].Batch) != 1 || canary[0].Batch[0].Status != tt.wantItems[0] {
+				t.Errorf("canary statuses = %+v, want one with the first item", canary)
+			}
+			if left, _ := os.ReadDir(cfg.InventoryDir); len(left) != 0 {
+				t.Errorf("inventories left behind: %v", left)
+			}
+		})
+	}
+}
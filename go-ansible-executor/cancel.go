@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// errCancelled is the cancel cause of runs stopped by a db.install.cancel message.
+var errCancelled = errors.New("cancelled by request")
+
+// exitCancelled is the ansible_exit_code of a cancelled run, as for a shell's Ctrl-C.
+const exitCancelled = 130
+
+// activeRuns holds the cancel func of every in-flight install run by id, then run
+// id: a redelivery can briefly run next to the original.
+var activeRuns = struct {
+	mu   sync.Mutex
+	runs map[int]map[string]context.CancelCauseFunc
+}{runs: map[int]map[string]context.CancelCauseFunc{}}
+
+// trackRun makes the run cancellable through db.install.cancel. done must be called
+// when the run is over.
+func trackRun(parent context.Context, id int, runID string) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	activeRuns.mu.Lock()
+	if activeRuns.runs[id] == nil {
+		activeRuns.runs[id] = map[string]context.CancelCauseFunc{}
+	}
+	activeRuns.runs[id][runID] = cancel
+	activeRuns.mu.Unlock()
+
+	return ctx, func() {
+		activeRuns.mu.Lock()
+		delete(activeRuns.runs[id], runID)
+		if len(activeRuns.runs[id]) == 0 {
+			delete(activeRuns.runs, id)
+		}
+		activeRuns.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelRuns cancels the in-flight runs of install id; it returns how many there were.
+func cancelRuns(id int) int {
+	activeRuns.mu.Lock()
+	defer activeRuns.mu.Unlock()
+	for _, cancel := range activeRuns.runs[id] {
+		cancel(errCancelled)
+	}
+	return len(activeRuns.runs[id])
+}
+
+// CancelRequest is the body of a db.install.cancel message.
+type CancelRequest struct {
+	ID int `json:"id"`
+}
+
+// handleCancel serves db.install.cancel. Every worker receives it and cancels its
+// own runs of the id; the reply says how many that were on this worker.
+func handleCancel(msg *nats.Msg) {
+	type reply struct {
+		ID        int    `json:"id"`
+		WorkerID  string `json:"worker_id"`
+		Cancelled int    `json:"cancelled"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	var req CancelRequest
+	r := reply{WorkerID: cfg.WorkerID}
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.ID == 0 {
+		r.Error = "invalid cancel request: want {\"id\": <install id>}"
+	} else {
+		r.ID, r.Cancelled = req.ID, cancelRuns(req.ID)
+		if r.Cancelled > 0 {
+			slog.Info("cancelling install", "id", req.ID, "runs", r.Cancelled)
+		}
+	}
+	// with several workers, only the one(s) running it should answer a request
+	if msg.Reply == "" || (r.Cancelled == 0 && r.Error == "") {
+		return
+	}
+	data, _ := json.Marshal(r)
+	if err := msg.Respond(data); err != nil {
+		slog.Warn("reply to cancel failed", "id", req.ID, "err", err)
+	}
+}
+
+// networkCategory is the category of a failed SSH wait or preflight: NETWORK,
+// unless the run was cancelled meanwhile.
+func networkCategory(ctx context.Context) string {
+	if errors.Is(context.Cause(ctx), errCancelled) {
+		return catCancelled
+	}
+	return catNetwork
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestCancelInstall(t *testing.T) {
+	setupWorker(t)
+	defer func(d time.Duration) { startDelay = d }(startDelay)
+	startDelay = 0
+	cfg.WorkerID = "worker-a"
+	nc := startNATS(t)
+	if _, err := nc.Subscribe(subjectCancel, handleCancel); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := nc.SubscribeSync(cfg.SubjectStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := testRequest()
+	req.IPAddress, req.Port = "127.0.0.1", fakeSSH(t)
+	data, _ := json.Marshal(req)
+	runner := &fakeRunner{results: map[string]fakeRun{"127.0.0.1": {hang: true}}}
+	go handleMessage(context.Background(), nc, runner, &nats.Msg{Subject: cfg.SubjectInstall, Data: data})
+
+	// the running status means the playbook has started
+	for {
+		msg, err := statuses.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var st InstallStatus
+		json.Unmarshal(msg.Data, &st)
+		if st.Stage == stageRunning {
+			break
+		}
+	}
+
+	type reply struct {
+		ID        int    `json:"id"`
+		WorkerID  string `json:"worker_id"`
+		Cancelled int    `json:"cancelled"`
+		Error     string `json:"error"`
+	}
+	tests := []struct {
+		name string
+		body string
+		want reply
+	}{
+		{name: "running", body: `{"id": 7}`, want: reply{ID: 7, WorkerID: "worker-a", Cancelled: 1}},
+		{name: "invalid", body: `{"id": "7"}`, want: reply{WorkerID: "worker-a", Error: `invalid cancel request: want {"id": <install id>}`}},
+	}
+	for _, tt := range tests {
+		msg, err := nc.Request(subjectCancel, []byte(tt.body), 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got reply
+		if err := json.Unmarshal(msg.Data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: reply = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	// nobody runs id 8, so nobody answers
+	if _, err := nc.Request(subjectCancel, []byte(`{"id": 8}`), 200*time.Millisecond); err == nil {
+		t.Error("got a reply to cancelling an unknown id")
+	}
+
+	var final InstallStatus
+	for final.Stage != stageFinal {
+		msg, err := statuses.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		final = InstallStatus{}
+		json.Unmarshal(msg.Data, &final)
+	}
+	if final.Status != "error" || final.Category != catCancelled || final.ErrorKind != kindCancelled {
+		t.Errorf("final = %q, category %q, error_kind %q; want error, %s, %s", final.Status, final.Category, final.ErrorKind, catCancelled, kindCancelled)
+	}
+}
+
+func TestPublishStatusCancelledExitCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		st         InstallStatus
+		wantCode   int
+		wantReason string
+	}{
+		{name: "before ansible ran", st: InstallStatus{Category: catCancelled}, wantCode: exitCancelled, wantReason: "cancelled"},
+		{name: "ansible killed", st: InstallStatus{Category: catCancelled, AnsibleExitCode: exitCancelled, ExitReason: "cancelled"}, wantCode: exitCancelled, wantReason: "cancelled"},
+		{name: "other error", st: InstallStatus{Category: catNetwork}},
+	}
+	nc := startNATS(t)
+	sub, err := nc.SubscribeSync(cfg.SubjectStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tt.st.ID, tt.st.Stage, tt.st.Status = 7, stageFinal, "error"
+		publishStatus(nc, tt.st, "")
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got InstallStatus
+		if err := json.Unmarshal(msg.Data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.AnsibleExitCode != tt.wantCode || got.ExitReason != tt.wantReason {
+			t.Errorf("%s: ansible_exit_code %d, exit_reason %q; want %d, %q", tt.name, got.AnsibleExitCode, got.ExitReason, tt.wantCode, tt.wantReason)
+		}
+	}
+}
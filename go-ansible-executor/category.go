@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+)
+
+// Category values for InstallStatus: one small, stable enum per failure so alerting
+// can key off a single field. Every error status carries exactly one.
+//
+//	CLIENT    invalid JSON, failed validation, db_type without a playbook
+//	AUTH      SSH login or host key rejected (ssh_diagnostic kind auth/hostkey)
+//	NETWORK   preflight or SSH wait failed, ssh_diagnostic kind network, ansible exit 4
+//	TIMEOUT   ansible ran past its timeout (exit 124)
+//	PLAYBOOK  the playbook failed, is missing (127) or matched no hosts (NO_HOSTS)
+//	INTERNAL  the worker failed: inventory or result dir, shutdown, exec errors, unparsable output
+//	CANCELLED stopped by a db.install.cancel message
+//	HOST_BUSY another run kept a target host past HOST_LOCK_WAIT
+const (
+	catClient    = "CLIENT"
+	catAuth      = "AUTH"
+	catNetwork   = "NETWORK"
+	catTimeout   = "TIMEOUT"
+	catPlaybook  = "PLAYBOOK"
+	catInternal  = "INTERNAL"
+	catCancelled = "CANCELLED"
+	catHostBusy  = "HOST_BUSY"
+)
+
+// Error kinds for InstallStatus.ErrorKind: a coarser, lower-case view of the
+// category that tells a request that will never work (validation) from one that
+// failed running.
+const (
+	kindValidation     = "validation"
+	kindUnreachable    = "unreachable"
+	kindTimeout        = "timeout"
+	kindPlaybookFailed = "playbook_failed"
+	kindInternal       = "internal"
+	kindCancelled      = "cancelled"
+	kindHostBusy       = "host_busy"
+)
+
+// errorKind maps a category to its error kind; "" for none.
+func errorKind(category string) string {
+	switch category {
+	case catClient:
+		return kindValidation
+	case catAuth, catNetwork:
+		return kindUnreachable
+	case catTimeout:
+		return kindTimeout
+	case catPlaybook:
+		return kindPlaybookFailed
+	case catInternal:
+		return kindInternal
+	case catCancelled:
+		return kindCancelled
+	case catHostBusy:
+		return kindHostBusy
+	}
+	return ""
+}
+
+// ansibleExitUnreachable is ansible's exit code when hosts were unreachable.
+const ansibleExitUnreachable = 4
+
+// exitReason names an ansible exit code for InstallStatus.ExitReason, from
+// ansible's documented codes and the worker's own (124 timeout, 127 missing
+// playbook, 130 cancelled); "" for 0. ansible also exits 4 when it can't parse the
+// playbook, so an exit 4 whose output has ERROR! but no UNREACHABLE! host is a
+// parse error.
+func exitReason(code int, output []byte) string {
+	switch code {
+	case 0:
+		return ""
+	case 1:
+		return "error"
+	case 2, 8: // 8: a failure stopped the play (any_errors_fatal, max_fail_percentage)
+		return "task_failed"
+	case 3:
+		return "unreachable"
+	case ansibleExitUnreachable:
+		if !bytes.Contains(output, []byte("UNREACHABLE!")) && bytes.Contains(output, []byte("ERROR!")) {
+			return "parse_error"
+		}
+		return "unreachable"
+	case 5:
+		return "bad_options"
+	case 99:
+		return "interrupted"
+	case 124:
+		return "timeout"
+	case 127:
+		return "not_found"
+	case exitCancelled:
+		return "cancelled"
+	case 250:
+		return "unexpected_error"
+	}
+	return "unknown"
+}
+
+// transient reports whether a failed run is worth repeating as is: it timed out
+// (124), or ansible couldn't reach a host (exit 4 or UNREACHABLE! results) for a
+// reason other than a rejected login. A task failure never is, whatever its
+// message says.
+func (r playResult) transient() bool {
+	switch r.category() {
+	case catTimeout:
+		return r.ExitCode == 124
+	case catNetwork:
+		return r.ExitCode == ansibleExitUnreachable || bytes.Contains(r.Output, []byte("UNREACHABLE!"))
+	}
+	return false
+}
+
+// category classifies a failed run; "" for a successful one. A Category set where
+// the run failed before ansible started takes precedence.
+func (r playResult) category() string {
+	if status, _, errCode := r.outcome(); status != "error" {
+		return ""
+	} else if errors.Is(r.Err, errCancelled) {
+		return catCancelled
+	} else if r.Category != "" {
+		return r.Category
+	} else if errCode == errCodeNoHosts {
+		return catPlaybook
+	}
+
+	switch r.ExitCode {
+	case 124:
+		return catTimeout
+	case 127, 2:
+		return catPlaybook
+	}
+	// the SSH diagnosis only speaks for unreachable hosts; a task's own "Permission
+	// denied" stays a PLAYBOOK failure
+	if r.ExitCode == ansibleExitUnreachable || bytes.Contains(r.Output, []byte("UNREACHABLE!")) {
+		if d := diagnoseSSH(r.Output); d != nil && d.Kind != "network" {
+			return catAuth
+		}
+		return catNetwork
+	}
+	var exitErr *exec.ExitError
+	if r.Err != nil && !errors.As(r.Err, &exitErr) {
+		// ansible never ran to completion (e.g. the binary is missing)
+		return catInternal
+	}
+	return catPlaybook
+}
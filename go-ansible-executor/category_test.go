@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestPlayResultCategory(t *testing.T) {
+	exitErr := func(code int) error { return fmt.Errorf("exit status %d: %w", code, &exec.ExitError{}) }
+	tests := []struct {
+		name   string
+		res    playResult
+		strict bool
+		want   string
+	}{
+		{name: "success", res: playResult{}},
+		{name: "task failed", res: playResult{ExitCode: 2, Err: exitErr(2)}, want: catPlaybook},
+		{name: "exit 1", res: playResult{ExitCode: 1, Err: exitErr(1)}, want: catPlaybook},
+		{
+			name: "permission denied",
+			res:  playResult{ExitCode: 4, Output: []byte("fatal: [h]: UNREACHABLE! => {\"msg\": \"root@h: Permission denied (publickey).\"}")},
+			want: catAuth,
+		},
+		{name: "host key", res: playResult{ExitCode: 4, Output: []byte("Failed to connect to the host via ssh: Host key verification failed.")}, want: catAuth},
+		{
+			name: "ssh timeout",
+			res:  playResult{ExitCode: 4, Output: []byte("Failed to connect to the host via ssh: ssh: connect to host h port 22: Connection timed out")},
+			want: catNetwork,
+		},
+		{
+			name: "unreachable",
+			res:  playResult{ExitCode: ansibleExitUnreachable, Output: []byte("fatal: [h]: UNREACHABLE! => {\"msg\": \"No route to host\"}")},
+			want: catNetwork,
+		},
+		{
+			name: "task permission denied",
+			res:  playResult{ExitCode: 2, Err: exitErr(2), Output: []byte("fatal: [h]: FAILED! => {\"msg\": \"Failed to connect to the host via ssh: Permission denied\"}")},
+			want: catPlaybook,
+		},
+		{
+			name: "task failed next to an unreachable host",
+			res:  playResult{ExitCode: 2, Err: exitErr(2), Output: []byte("fatal: [h2]: UNREACHABLE! => {\"msg\": \"Failed to connect to the host via ssh: Connection timed out\"}")},
+			want: catPlaybook,
+		},
+		{name: "timed out", res: playResult{ExitCode: 124, Err: errors.New("ansible-playbook timed out after 30m0s")}, want: catTimeout},
+		{
+			name: "timed out after an ssh error",
+			res:  playResult{ExitCode: 124, Err: errors.New("ansible-playbook timed out after 30m0s"), Output: []byte("fatal: [h]: UNREACHABLE! => {\"msg\": \"Failed to connect to the host via ssh: Permission denied\"}")},
+			want: catTimeout,
+		},
+		{
+			name: "exit 1 with an unreachable host",
+			res:  playResult{ExitCode: 1, Err: exitErr(1), Output: []byte("fatal: [h]: UNREACHABLE! => {\"msg\": \"Failed to connect to the host via ssh: Permission denied\"}")},
+			want: catAuth,
+		},
+		{name: "playbook missing", res: playResult{ExitCode: 127, Err: exitErr(127)}, want: catPlaybook},
+		{name: "no hosts matched", res: playResult{Output: []byte(noHostsOutput)}, strict: true, want: catPlaybook},
+		{name: "no hosts matched, not strict", res: playResult{Output: []byte(noHostsOutput)}},
+		{
+			name: "binary missing",
+			res:  playResult{ExitCode: 1, Err: &exec.Error{Name: "ansible-playbook", Err: exec.ErrNotFound}},
+			want: catInternal,
+		},
+		{
+			name: "preset before ansible ran",
+			res:  playResult{Err: errors.New("create result dir: permission denied"), Category: catInternal},
+			want: catInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(s bool) { cfg.StrictNoHosts = s }(cfg.StrictNoHosts)
+			cfg.StrictNoHosts = tt.strict
+			if got := tt.res.category(); got != tt.want {
+				t.Errorf("category() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		category string
+		want     string
+	}{
+		{catClient, kindValidation},
+		{catAuth, kindUnreachable},
+		{catNetwork, kindUnreachable},
+		{catTimeout, kindTimeout},
+		{catPlaybook, kindPlaybookFailed},
+		{catInternal, kindInternal},
+		{catCancelled, kindCancelled},
+		{catHostBusy, kindHostBusy},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := errorKind(tt.category); got != tt.want {
+			t.Errorf("errorKind(%q) = %q, want %q", tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestExitReason(t *testing.T) {
+	tests := []struct {
+		code   int
+		output string
+		want   string
+	}{
+		{code: 0, want: ""},
+		{code: 1, want: "error"},
+		{code: 2, want: "task_failed"},
+		{code: 8, want: "task_failed"},
+		{code: 3, want: "unreachable"},
+		{code: 4, output: "fatal: [10.0.0.1]: UNREACHABLE! => {}", want: "unreachable"},
+		{code: 4, output: "ERROR! We were unable to read either as JSON nor YAML", want: "parse_error"},
+		{code: 4, output: "ERROR! x\nfatal: [10.0.0.1]: UNREACHABLE! => {}", want: "unreachable"},
+		{code: 5, want: "bad_options"},
+		{code: 99, want: "interrupted"},
+		{code: 124, want: "timeout"},
+		{code: 127, want: "not_found"},
+		{code: exitCancelled, want: "cancelled"},
+		{code: 250, want: "unexpected_error"},
+		{code: 42, want: "unknown"},
+	}
+	for _, tt := range tests {
+		if got := exitReason(tt.code, []byte(tt.output)); got != tt.want {
+			t.Errorf("exitReason(%d, %q) = %q, want %q", tt.code, tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// chunkHeadroom leaves room for headers and protocol overhead in each chunk message.
+const chunkHeadroom = 1024
+
+// Headers carried by each db.install.log.chunk message, along with Run-Id
+// (hdrRunID). Consumers reassemble the output by (Install-Id, Run-Id,
+// Install-Timestamp), ordering by Chunk-Index.
+const (
+	hdrInstallID        = "Install-Id"
+	hdrInstallTimestamp = "Install-Timestamp"
+	hdrChunkIndex       = "Chunk-Index" // 0-based
+	hdrChunkTotal       = "Chunk-Total"
+)
+
+// publishOutputChunks splits st.AnsibleOutput into messages that fit maxPayload and
+// publishes them in order. It returns how many chunks were sent.
+func publishOutputChunks(nc *nats.Conn, st InstallStatus, maxPayload int64) (int, error) {
+	out := []byte(st.AnsibleOutput)
+	size := int(maxPayload) - chunkHeadroom
+	if size <= 0 {
+		size = int(maxPayload)
+	}
+	total := (len(out) + size - 1) / size
+
+	for i := 0; i < total; i++ {
+		end := min((i+1)*size, len(out))
+		msg := nats.NewMsg(subjectLogChunk)
+		msg.Data = out[i*size : end]
+		msg.Header.Set(hdrInstallID, strconv.Itoa(st.ID))
+		msg.Header.Set(hdrRunID, st.RunID)
+		msg.Header.Set(hdrInstallTimestamp, st.Timestamp.Format(time.RFC3339Nano))
+		msg.Header.Set(hdrChunkIndex, strconv.Itoa(i))
+		msg.Header.Set(hdrChunkTotal, strconv.Itoa(total))
+		if err := publishOrBuffer(nc, msg); err != nil {
+			return i, err
+		}
+	}
+	slog.Info("published output in chunks", "id", st.ID, "chunks", total)
+	return total, nil
+}
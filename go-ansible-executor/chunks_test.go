@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// readChunks reassembles n db.install.log.chunk messages by Chunk-Index,
+// checking the headers every chunk carries.
+func readChunks(t *testing.T, sub *nats.Subscription, st InstallStatus, n int) string {
+	t.Helper()
+	parts := make([]string, n)
+	for range n {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Header.Get(hdrInstallID); got != strconv.Itoa(st.ID) {
+			t.Errorf("%s = %q, want %d", hdrInstallID, got, st.ID)
+		}
+		if got := msg.Header.Get(hdrRunID); got != st.RunID {
+			t.Errorf("%s = %q, want %q", hdrRunID, got, st.RunID)
+		}
+		if got := msg.Header.Get(hdrChunkTotal); got != strconv.Itoa(n) {
+			t.Errorf("%s = %q, want %d", hdrChunkTotal, got, n)
+		}
+		i, err := strconv.Atoi(msg.Header.Get(hdrChunkIndex))
+		if err != nil || i < 0 || i >= n || parts[i] != "" {
+			t.Fatalf("bad or repeated %s %q", hdrChunkIndex, msg.Header.Get(hdrChunkIndex))
+		}
+		parts[i] = string(msg.Data)
+	}
+	return strings.Join(parts, "")
+}
+
+func TestPublishOutputChunks(t *testing.T) {
+	const size = 100 // bytes per chunk
+	tests := []struct {
+		name       string
+		outputLen  int
+		wantChunks int
+	}{
+		{name: "one short chunk", outputLen: 10, wantChunks: 1},
+		{name: "exactly one chunk", outputLen: size, wantChunks: 1},
+		{name: "exact multiple", outputLen: 3 * size, wantChunks: 3},
+		{name: "partial last chunk", outputLen: 2*size + 1, wantChunks: 3},
+	}
+	nc := startNATS(t)
+	sub, err := nc.SubscribeSync(subjectLogChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			for i := range tt.outputLen {
+				b.WriteByte('a' + byte(i%26))
+			}
+			st := InstallStatus{ID: 7, RunID: "run1", AnsibleOutput: b.String(), Timestamp: time.Now()}
+
+			n, err := publishOutputChunks(nc, st, size+chunkHeadroom)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantChunks {
+				t.Errorf("published %d chunks, want %d", n, tt.wantChunks)
+			}
+			if got := readChunks(t, sub, st, n); got != st.AnsibleOutput {
+				t.Errorf("reassembled %d bytes, want the %d of the output", len(got), len(st.AnsibleOutput))
+			}
+		})
+	}
+}
+
+// A status over the server's max payload goes out without its output, which
+// follows in chunks.
+func TestPublishStatusChunksOversizedOutput(t *testing.T) {
+	nc := startNATS(t, func(o *server.Options) { o.MaxPayload = 4096 })
+	if nc.MaxPayload() != 4096 {
+		t.Fatalf("max payload = %d", nc.MaxPayload())
+	}
+	statuses, err := nc.SubscribeSync(cfg.SubjectStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := nc.SubscribeSync(subjectLogChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := InstallStatus{ID: 7, Status: "success", AnsibleOutput: strings.Repeat("ok: [10.0.0.1]\n", 1000), Timestamp: time.Now()}
+
+	publishStatus(nc, st, "")
+
+	msg, err := statuses.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got InstallStatus
+	if err := json.Unmarshal(msg.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.AnsibleOutput != "" || got.OutputChunks < 2 {
+		t.Fatalf("status has %d bytes of output and %d chunks, want none and several", len(got.AnsibleOutput), got.OutputChunks)
+	}
+	if out := readChunks(t, chunks, st, got.OutputChunks); out != st.AnsibleOutput {
+		t.Errorf("reassembled %d bytes, want the %d of the output", len(out), len(st.AnsibleOutput))
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the worker settings resolved from the environment at startup.
+// It is exposed (redacted) on db.install.config: tag anything sensitive `secret:"true"`.
+type Config struct {
+	NatsURL string `json:"nats_url"`
+
+	// Subjects requests are taken from and statuses published on (SUBJECT_INSTALL,
+	// SUBJECT_STATUS), and the queue group shared by the workers (QUEUE_GROUP).
+	// Change all three to run a separate environment on the same NATS cluster.
+	SubjectInstall string `json:"subject_install"`
+	SubjectStatus  string `json:"subject_status"`
+	QueueGroup     string `json:"queue_group"`
+
+	// Optional NATS credentials and TLS (NATS_CREDS, NATS_TOKEN, NATS_TLS_CA,
+	// NATS_TLS_CERT, NATS_TLS_KEY); unset connects anonymously in plaintext.
+	NatsCreds   string `json:"nats_creds"`
+	NatsToken   string `json:"nats_token" secret:"true"`
+	NatsTLSCA   string `json:"nats_tls_ca"`
+	NatsTLSCert string `json:"nats_tls_cert"`
+	NatsTLSKey  string `json:"nats_tls_key"`
+
+	// Consume db.install from a durable JetStream consumer with explicit acks
+	// (JETSTREAM) instead of a core NATS queue subscription.
+	JetStream           bool          `json:"jetstream"`
+	JetStreamStream     string        `json:"jetstream_stream"`
+	JetStreamDurable    string        `json:"jetstream_durable"`
+	JetStreamAckWait    time.Duration `json:"jetstream_ack_wait"`
+	JetStreamMaxDeliver int           `json:"jetstream_max_deliver"`
+	JetStreamNakDelay   time.Duration `json:"jetstream_nak_delay"`
+
+	// Flags callers may pass through ExtraArgs (e.g. "--diff", "--flush-cache").
+	// Empty means no passthrough args are accepted.
+	AllowedExtraArgs []string `json:"allowed_extra_args"`
+
+	// JSON file of canonical db_type => playbook path; empty uses the built-in list.
+	// Reloaded on SIGHUP or a message to db.install.reload.playbooks.
+	PlaybookAllowlistFile string `json:"playbook_allowlist_file"`
+
+	// Base for relative playbook paths (PLAYBOOK_DIR). Unset, the built-in list uses
+	// playbooks/ and allowlist paths are relative to the working directory.
+	PlaybookDir string `json:"playbook_dir"`
+
+	// ansible-playbook to run (ANSIBLE_PLAYBOOK_BIN), a PATH name or a path; the
+	// ad-hoc `ansible` is taken from the same directory.
+	AnsiblePlaybookBin string `json:"ansible_playbook_bin"`
+
+	// ANSIBLE_HOST_KEY_CHECKING given to every ansible run, unless the request sends
+	// known_hosts (then it is always on); empty leaves it to ansible.cfg.
+	HostKeyChecking string `json:"host_key_checking"`
+
+	// Oldest ansible-core the playbooks work with (MIN_ANSIBLE_VERSION); the worker
+	// won't start with an older or missing ansible-playbook.
+	MinAnsibleVersion string `json:"min_ansible_version"`
+
+	// requirements.yml to `ansible-galaxy install -r` on startup (GALAXY_REQUIREMENTS);
+	// the worker exits if that fails. Empty skips it.
+	GalaxyRequirements string `json:"galaxy_requirements"`
+
+	// Where inventories, SSH key and known_hosts files are written (INVENTORY_DIR).
+	InventoryDir string `json:"inventory_dir"`
+
+	// How many ansible-playbook runs may execute at once in this process.
+	MaxConcurrentRuns int `json:"max_concurrent_runs"`
+
+	// Re-run a playbook up to MAX_RETRIES times after a transient (NETWORK or TIMEOUT)
+	// failure, waiting RETRY_BACKOFF, then twice that, and so on.
+	MaxRetries   int           `json:"max_retries"`
+	RetryBackoff time.Duration `json:"retry_backoff"`
+
+	// Status publishes kept while NATS is down and its reconnect buffer is full
+	// (STATUS_BUFFER_SIZE); they are replayed on reconnect, oldest dropped first.
+	StatusBufferSize int `json:"status_buffer_size"`
+
+	// Published ansible output is cut to this many bytes (MAX_OUTPUT_BYTES).
+	MaxOutputBytes int `json:"max_output_bytes"`
+
+	// Report a run where no host matched as an error (NO_HOSTS) instead of success.
+	StrictNoHosts bool `json:"strict_no_hosts"`
+
+	// Quick DNS + SSH port check before writing an inventory (PREFLIGHT_VALIDATE),
+	// and an `ansible -m ping` through it before the playbook (PREFLIGHT_PING).
+	PreflightValidate bool          `json:"preflight_validate"`
+	PreflightTimeout  time.Duration `json:"preflight_timeout"`
+	PreflightPing     bool          `json:"preflight_ping"`
+
+	// Vault password file for requests with vault-encrypted values and no
+	// vault_password of their own (ANSIBLE_VAULT_PASSWORD_FILE).
+	VaultPasswordFile string `json:"vault_password_file"`
+
+	// Forward install requests that aren't valid JSON here, as sent (DLQ_SUBJECT);
+	// empty drops them after the error status.
+	DLQSubject string `json:"dlq_subject"`
+
+	// Reject request messages with unknown fields (STRICT_REQUESTS).
+	StrictRequests bool `json:"strict_requests"`
+
+	// Run ansible-playbook --syntax-check before each playbook (SYNTAX_CHECK).
+	SyntaxCheck bool `json:"syntax_check"`
+
+	// Print one JSON summary line per run to stdout (SUMMARY_LINE).
+	SummaryLine bool `json:"summary_line"`
+
+	// Serve inventories through a named pipe instead of a regular file (INVENTORY_FIFO).
+	InventoryFIFO bool `json:"inventory_fifo"`
+
+	// Inventory file format (INVENTORY_FORMAT): "ini" (one host line) or "yaml".
+	InventoryFormat string `json:"inventory_format"`
+
+	// Keep a redacted copy of each inventory here (DEBUG_INVENTORY_DIR); empty disables.
+	DebugInventoryDir string `json:"debug_inventory_dir"`
+
+	// Keep each run's full ansible output here (OUTPUT_LOG_DIR); empty disables it.
+	OutputLogDir string `json:"output_log_dir"`
+
+	// Directory for per-run result files (RESULT_DIR); empty disables result_file.
+	ResultDir string `json:"result_dir"`
+
+	// Run duration estimate until enough successful runs of a db_type were seen (ETA_DEFAULT).
+	ETADefault time.Duration `json:"eta_default"`
+
+	// Advisory flock held for the process lifetime (INSTANCE_LOCK). LockMode is
+	// "exit" (fail if held) or "standby" (wait for the holder to go away).
+	InstanceLock bool   `json:"instance_lock"`
+	LockFile     string `json:"lock_file"`
+	LockMode     string `json:"lock_mode"`
+
+	// Inventories not in use and older than this are swept when space runs out.
+	StaleInventoryAge time.Duration `json:"stale_inventory_age"`
+
+	// Listen address of the WebSocket status relay (WS_ADDR); empty disables it.
+	WSAddr string `json:"ws_addr"`
+	// Browser origins besides the relay's own host that may connect to it
+	// (WS_ALLOWED_ORIGINS), e.g. "https://dashboard.example.com".
+	WSAllowedOrigins []string `json:"ws_allowed_origins"`
+
+	// Random delay up to this before connecting, also added to each reconnect wait
+	// (STARTUP_JITTER_MAX); spreads out a fleet restarting at once. Zero disables.
+	StartupJitterMax time.Duration `json:"startup_jitter_max"`
+
+	// List the host var names the inventory set in every final status, not just
+	// failed ones (REPORT_INVENTORY_VARS).
+	ReportInventoryVars bool `json:"report_inventory_vars"`
+
+	// Tag each ansible output line on stdout with "<LOG_IDENTIFIER> id=<request id> | "
+	// so journald entries can be filtered per install; empty leaves lines untouched.
+	LogIdentifier string `json:"log_identifier"`
+
+	// On SIGTERM, stop accepting requests and give in-flight runs this long to
+	// finish before cancelling them (SHUTDOWN_GRACE). Zero cancels immediately.
+	ShutdownGrace time.Duration `json:"shutdown_grace"`
+
+	// Bound on draining the NATS connection at exit (DRAIN_TIMEOUT); it is closed
+	// without flushing after that.
+	DrainTimeout time.Duration `json:"drain_timeout"`
+
+	// Umask applied while the worker creates inventory files (FILE_UMASK, octal).
+	// Group/other bits are always masked.
+	FileUmask octal `json:"file_umask"`
+
+	// Listen address of the /healthz endpoint (HEALTH_ADDR); empty disables it.
+	HealthAddr string `json:"health_addr"`
+
+	// Listen address of the Prometheus /metrics endpoint (METRICS_ADDR); empty disables it.
+	MetricsAddr string `json:"metrics_addr"`
+
+	// Worker log output: text or json (LOG_FORMAT), and the minimum level logged:
+	// debug, info, warn or error (LOG_LEVEL).
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+
+	// Export traces to this OTLP/HTTP collector (OTEL_EXPORTER_OTLP_ENDPOINT, e.g.
+	// http://otel-collector:4318) as OTEL_SERVICE_NAME; empty disables the export.
+	OTLPEndpoint    string `json:"otlp_endpoint"`
+	OTelServiceName string `json:"otel_service_name"`
+
+	// How long a finished run's idempotency_key is remembered (IDEMPOTENCY_TTL).
+	IdempotencyTTL time.Duration `json:"idempotency_ttl"`
+
+	// How long a run waits for another run on the same host to end (HOST_LOCK_WAIT);
+	// 0 waits as long as it takes.
+	HostLockWait time.Duration `json:"host_lock_wait"`
+
+	// Identifies this worker in published statuses (WORKER_ID); defaults to the hostname.
+	WorkerID string `json:"worker_id"`
+}
+
+// cfg is the effective configuration, loaded once in main.
+var cfg Config
+
+func loadConfig() Config {
+	inventoryDir := envOr("INVENTORY_DIR", "inventories")
+	queueGroup := envOr("QUEUE_GROUP", defaultQueueGroup)
+	return Config{
+		NatsURL:               envOr("NATS_URL", defaultNatsURL),
+		SubjectInstall:        envOr("SUBJECT_INSTALL", defaultSubjectInstall),
+		SubjectStatus:         envOr("SUBJECT_STATUS", defaultSubjectStatus),
+		QueueGroup:            queueGroup,
+		PlaybookDir:           os.Getenv("PLAYBOOK_DIR"),
+		HostKeyChecking:       os.Getenv("ANSIBLE_HOST_KEY_CHECKING"),
+		MinAnsibleVersion:     envOr("MIN_ANSIBLE_VERSION", "2.15"),
+		GalaxyRequirements:    os.Getenv("GALAXY_REQUIREMENTS"),
+		AnsiblePlaybookBin:    envOr("ANSIBLE_PLAYBOOK_BIN", "ansible-playbook"),
+		InventoryDir:          inventoryDir,
+		NatsCreds:             os.Getenv("NATS_CREDS"),
+		NatsToken:             os.Getenv("NATS_TOKEN"),
+		NatsTLSCA:             os.Getenv("NATS_TLS_CA"),
+		NatsTLSCert:           os.Getenv("NATS_TLS_CERT"),
+		NatsTLSKey:            os.Getenv("NATS_TLS_KEY"),
+		JetStream:             envBool("JETSTREAM"),
+		JetStreamStream:       envOr("JETSTREAM_STREAM", "DB_INSTALL"),
+		JetStreamDurable:      envOr("JETSTREAM_DURABLE", queueGroup),
+		JetStreamAckWait:      envDuration("JETSTREAM_ACK_WAIT", time.Minute),
+		JetStreamMaxDeliver:   envInt("JETSTREAM_MAX_DELIVER", 5),
+		JetStreamNakDelay:     envDuration("JETSTREAM_NAK_DELAY", 30*time.Second),
+		AllowedExtraArgs:      envList("ALLOWED_EXTRA_ARGS"),
+		PlaybookAllowlistFile: os.Getenv("PLAYBOOK_ALLOWLIST_FILE"),
+		MaxConcurrentRuns:     envInt("MAX_CONCURRENT_RUNS", 4),
+		MaxRetries:            envInt("MAX_RETRIES", 0),
+		RetryBackoff:          envDuration("RETRY_BACKOFF", 30*time.Second),
+		MaxOutputBytes:        envPositiveInt("MAX_OUTPUT_BYTES", defaultMaxOutputBytes),
+		StatusBufferSize:      envPositiveInt("STATUS_BUFFER_SIZE", 1000),
+		StrictNoHosts:         envBool("STRICT_NO_HOSTS"),
+		PreflightValidate:     envBool("PREFLIGHT_VALIDATE"),
+		PreflightTimeout:      envDuration("PREFLIGHT_TIMEOUT", 3*time.Second),
+		PreflightPing:         envBool("PREFLIGHT_PING"),
+		SyntaxCheck:           envBool("SYNTAX_CHECK"),
+		StrictRequests:        envBool("STRICT_REQUESTS"),
+		DLQSubject:            os.Getenv("DLQ_SUBJECT"),
+		VaultPasswordFile:     os.Getenv("ANSIBLE_VAULT_PASSWORD_FILE"),
+		SummaryLine:           envBool("SUMMARY_LINE"),
+		InventoryFIFO:         envBool("INVENTORY_FIFO"),
+		InventoryFormat:       envChoice("INVENTORY_FORMAT", "ini", "yaml"),
+		DebugInventoryDir:     os.Getenv("DEBUG_INVENTORY_DIR"),
+		ResultDir:             os.Getenv("RESULT_DIR"),
+		OutputLogDir:          os.Getenv("OUTPUT_LOG_DIR"),
+		ETADefault:            envDuration("ETA_DEFAULT", 10*time.Minute),
+		InstanceLock:          envBool("INSTANCE_LOCK"),
+		LockFile:              envOr("LOCK_FILE", filepath.Join(inventoryDir, ".ansible-executor.lock")),
+		LockMode:              envOr("LOCK_MODE", "exit"),
+		StaleInventoryAge:     envDuration("STALE_INVENTORY_AGE", time.Hour),
+		WSAddr:                os.Getenv("WS_ADDR"),
+		WSAllowedOrigins:      envList("WS_ALLOWED_ORIGINS"),
+		StartupJitterMax:      envDuration("STARTUP_JITTER_MAX", 0),
+		ReportInventoryVars:   envBool("REPORT_INVENTORY_VARS"),
+		LogIdentifier:         os.Getenv("LOG_IDENTIFIER"),
+		ShutdownGrace:         envDuration("SHUTDOWN_GRACE", 0),
+		DrainTimeout:          envDuration("DRAIN_TIMEOUT", 20*time.Second),
+		FileUmask:             envUmask("FILE_UMASK", 0o077),
+		HealthAddr:            os.Getenv("HEALTH_ADDR"),
+		MetricsAddr:           os.Getenv("METRICS_ADDR"),
+		LogFormat:             envLogFormat(),
+		LogLevel:              envLogLevel(),
+		OTLPEndpoint:          os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		OTelServiceName:       envOr("OTEL_SERVICE_NAME", "go-ansible-executor"),
+		IdempotencyTTL:        envDuration("IDEMPOTENCY_TTL", 10*time.Minute),
+		HostLockWait:          envDuration("HOST_LOCK_WAIT", 30*time.Minute),
+		WorkerID:              envOr("WORKER_ID", hostname()),
+	}
+}
+
+// hostname is the machine's host name, or "" if it can't be read.
+func hostname() string {
+	h, _ := os.Hostname()
+	return h
+}
+
+// envList splits a comma-separated env value, dropping empty items.
+func envList(k string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(k), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// envChoice returns the env value if it is one of allowed, else allowed[0] (the default).
+func envChoice(k string, allowed ...string) string {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(k)))
+	if v == "" {
+		return allowed[0]
+	}
+	if !slices.Contains(allowed, v) {
+		slog.Warn("invalid env value, using default", "env", k, "value", v, "allowed", allowed, "default", allowed[0])
+		return allowed[0]
+	}
+	return v
+}
+
+// envInt parses an integer env value, falling back to def when unset or invalid.
+func envInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid env value, using default", "env", k, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envPositiveInt is envInt for values that must be at least 1.
+func envPositiveInt(k string, def int) int {
+	n := envInt(k, def)
+	if n < 1 {
+		slog.Warn("env value must be positive, using default", "env", k, "value", n, "default", def)
+		return def
+	}
+	return n
+}
+
+// envBool reports whether an env value is set to a true-ish value ("true", "1", ...).
+func envBool(k string) bool {
+	v, err := strconv.ParseBool(os.Getenv(k))
+	return err == nil && v
+}
+
+// envDuration parses a Go duration env value (e.g. "5s"), falling back to def.
+func envDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid env value, using default", "env", k, "value", v, "default", def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestMaxOutputBytes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultMaxOutputBytes},
+		{"500", 500},
+		{"1048576", 1048576},
+		{"0", defaultMaxOutputBytes},
+		{"-5", defaultMaxOutputBytes},
+		{"10k", defaultMaxOutputBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("MAX_OUTPUT_BYTES", tt.value)
+			if got := loadConfig().MaxOutputBytes; got != tt.want {
+				t.Errorf("MAX_OUTPUT_BYTES=%q: max_output_bytes = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubjectsAndQueueGroup(t *testing.T) {
+	tests := []struct {
+		name                            string
+		env                             map[string]string
+		install, status, queue, durable string
+	}{
+		{
+			name:    "defaults",
+			install: "db.install", status: "db.install.status", queue: "db-install-workers", durable: "db-install-workers",
+		},
+		{
+			name:    "staging",
+			env:     map[string]string{"SUBJECT_INSTALL": "stg.db.install", "SUBJECT_STATUS": "stg.db.install.status", "QUEUE_GROUP": "stg-workers"},
+			install: "stg.db.install", status: "stg.db.install.status", queue: "stg-workers", durable: "stg-workers",
+		},
+		{
+			name:    "own durable",
+			env:     map[string]string{"QUEUE_GROUP": "stg-workers", "JETSTREAM_DURABLE": "stg-durable"},
+			install: "db.install", status: "db.install.status", queue: "stg-workers", durable: "stg-durable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"SUBJECT_INSTALL", "SUBJECT_STATUS", "QUEUE_GROUP", "JETSTREAM_DURABLE"} {
+				t.Setenv(k, tt.env[k])
+			}
+			c := loadConfig()
+			if c.SubjectInstall != tt.install || c.SubjectStatus != tt.status || c.QueueGroup != tt.queue || c.JetStreamDurable != tt.durable {
+				t.Errorf("got %s, %s, %s, durable %s; want %s, %s, %s, durable %s",
+					c.SubjectInstall, c.SubjectStatus, c.QueueGroup, c.JetStreamDurable, tt.install, tt.status, tt.queue, tt.durable)
+			}
+		})
+	}
+}
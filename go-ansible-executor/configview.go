@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// handleConfig replies on db.install.config with the worker's effective configuration.
+// It is read-only and never includes secrets.
+func handleConfig(msg *nats.Msg) {
+	if msg.Reply == "" {
+		return
+	}
+	view := map[string]any{
+		"config": publicConfig(cfg),
+		"subjects": map[string]string{
+			"install":          cfg.SubjectInstall,
+			"status":           cfg.SubjectStatus,
+			"facts":            subjectFacts,
+			"log_chunk":        subjectLogChunk,
+			"logs":             subjectLogsPrefix + ".<id>",
+			"reload_playbooks": subjectReloadPlaybooks,
+			"config":           subjectConfig,
+			"stats":            subjectStats,
+		},
+		"play_timeout":     playTimeout.String(),
+		"max_play_timeout": maxPlayTimeout.String(),
+		"facts_timeout":    factsTimeout.String(),
+		"playbooks":        *playbookAllowlist.Load(),
+	}
+	data, err := json.Marshal(view)
+	if err != nil {
+		slog.Error("marshal config failed", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		slog.Warn("config reply failed", "err", err)
+	}
+}
+
+// publicConfig renders c keyed by json tag, with durations as strings, the NATS URL
+// stripped of credentials, and `secret:"true"` fields left out.
+func publicConfig(c Config) map[string]any {
+	out := map[string]any{}
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("secret") == "true" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		val := v.Field(i).Interface()
+		if d, ok := val.(time.Duration); ok {
+			val = d.String()
+		}
+		out[name] = val
+	}
+	out["nats_url"] = redactURLs(c.NatsURL)
+	return out
+}
+
+// redactURLs redacts each URL of a comma-separated server list such as NATS_URL.
+func redactURLs(raw string) string {
+	urls := strings.Split(raw, ",")
+	for i, u := range urls {
+		urls[i] = redactURL(strings.TrimSpace(u))
+	}
+	return strings.Join(urls, ",")
+}
+
+// redactURL hides any user/password/token in a URL such as nats://token@host:4222.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[unparseable]"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	return u.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestRedactURLs(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "nats://127.0.0.1:4222", want: "nats://127.0.0.1:4222"},
+		{raw: "nats://s3cret@nats:4222", want: "nats://REDACTED@nats:4222"},
+		{raw: "nats://user:pw@a:4222,nats://b:4222", want: "nats://REDACTED@a:4222,nats://b:4222"},
+		{raw: "nats://a:4222, nats://token@b:4222", want: "nats://a:4222,nats://REDACTED@b:4222"},
+	}
+	for _, tt := range tests {
+		if got := redactURLs(tt.raw); got != tt.want {
+			t.Errorf("redactURLs(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// dbTypeAliases maps every accepted db_type spelling to its canonical engine name.
+var dbTypeAliases = map[string]string{
+	"postgresql": "postgresql",
+	"postgres":   "postgresql",
+	"pg":         "postgresql",
+	"mysql":      "mysql",
+	"mariadb":    "mariadb",
+}
+
+// knownDBVersions lists the versions a db_type suffix may carry, per canonical engine:
+// the ones its playbook can install. On Rocky 9 PostgreSQL 13 is the distro default,
+// 15 and 16 are dnf module streams.
+var knownDBVersions = map[string][]string{
+	"postgresql": {"13", "15", "16"},
+}
+
+// defaultDBPorts is the port each canonical engine listens on unless db_port overrides it.
+var defaultDBPorts = map[string]int{
+	"postgresql": 5432,
+	"mysql":      3306,
+	"mariadb":    3306,
+	"mongodb":    27017,
+	"redis":      6379,
+}
+
+// effectiveDBPort returns the request's db_port, or the default of the canonical
+// engine (0 if unknown). The playbooks apply the same defaults when db_port is unset.
+func effectiveDBPort(r InstallRequest, canonical string) int {
+	if r.DBPort != 0 {
+		return r.DBPort
+	}
+	return defaultDBPorts[canonical]
+}
+
+// validateDBVersion checks a request's db_version against knownDBVersions.
+func validateDBVersion(canonical, version string) error {
+	known := knownDBVersions[canonical]
+	if len(known) == 0 {
+		return fmt.Errorf("%s does not take a db_version", canonical)
+	}
+	if !slices.Contains(known, version) {
+		return fmt.Errorf("unsupported %s db_version %q (known: %s)", canonical, version, strings.Join(known, ", "))
+	}
+	return nil
+}
+
+// normalizeDBType canonicalizes a db_type and derives the version from a numeric
+// suffix, e.g. "postgresql15" => ("postgresql", "15"), "pg-16" => ("postgresql", "16").
+// A bare alias returns an empty version (the playbook default). The playbook allowlist
+// decides what is supported, so validation and selectPlaybook always agree.
+func normalizeDBType(dbType string) (canonical, version string, err error) {
+	s := strings.ToLower(strings.TrimSpace(dbType))
+
+	// split off trailing digits, with an optional "-" or "_" separator
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	name, version := s[:i], s[i:]
+	if version != "" {
+		name = strings.TrimRight(name, "-_")
+	}
+
+	canonical, ok := dbTypeAliases[name]
+	if !ok {
+		canonical = name // allowlist entries without an alias use their own name
+	}
+	if _, ok := (*playbookAllowlist.Load())[canonical]; !ok {
+		return "", "", fmt.Errorf("unsupported db_type %q (supported: %s)", dbType, strings.Join(supportedDBTypes(), ", "))
+	}
+	if version != "" && len(knownDBVersions[canonical]) == 0 {
+		return "", "", fmt.Errorf("db_type %q: %s does not take a version suffix", dbType, canonical)
+	}
+	if version != "" && !slices.Contains(knownDBVersions[canonical], version) {
+		return "", "", fmt.Errorf("unsupported %s version %q in db_type %q (known: %s)",
+			canonical, version, dbType, strings.Join(knownDBVersions[canonical], ", "))
+	}
+	return canonical, version, nil
+}
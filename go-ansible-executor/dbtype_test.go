@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDBType(t *testing.T) {
+	playbookAllowlist.Store(&map[string]string{
+		"postgresql": "postgresql.yml",
+		"mysql":      "mysql.yml",
+		"mongodb":    "mongodb.yml",
+	})
+	tests := []struct {
+		dbType        string
+		wantCanonical string
+		wantVersion   string
+		wantErr       string
+	}{
+		{dbType: "postgresql", wantCanonical: "postgresql"},
+		{dbType: "postgres", wantCanonical: "postgresql"},
+		{dbType: " PG ", wantCanonical: "postgresql"},
+		{dbType: "postgresql15", wantCanonical: "postgresql", wantVersion: "15"},
+		{dbType: "postgres13", wantCanonical: "postgresql", wantVersion: "13"},
+		{dbType: "pg16", wantCanonical: "postgresql", wantVersion: "16"},
+		{dbType: "pg-16", wantCanonical: "postgresql", wantVersion: "16"},
+		{dbType: "PostgreSQL_15", wantCanonical: "postgresql", wantVersion: "15"},
+		{dbType: "mysql", wantCanonical: "mysql"},
+		{dbType: "mongodb", wantCanonical: "mongodb"}, // allowlisted without an alias
+		{dbType: "pg12", wantErr: `unsupported postgresql version "12"`},
+		{dbType: "mysql8", wantErr: "mysql does not take a version suffix"},
+		{dbType: "oracle", wantErr: `unsupported db_type "oracle"`},
+		{dbType: "mariadb", wantErr: `unsupported db_type "mariadb"`}, // aliased but not allowlisted
+		{dbType: "15", wantErr: `unsupported db_type "15"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dbType, func(t *testing.T) {
+			canonical, version, err := normalizeDBType(tt.dbType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical != tt.wantCanonical || version != tt.wantVersion {
+				t.Errorf("got (%q, %q), want (%q, %q)", canonical, version, tt.wantCanonical, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestEffectiveDBPort(t *testing.T) {
+	tests := []struct {
+		canonical string
+		dbPort    int
+		want      int
+	}{
+		{"postgresql", 0, 5432},
+		{"mysql", 0, 3306},
+		{"cassandra", 0, 0},
+		{"postgresql", 6432, 6432},
+		{"mysql", 3307, 3307},
+	}
+	for _, tt := range tests {
+		if got := effectiveDBPort(InstallRequest{DBPort: tt.dbPort}, tt.canonical); got != tt.want {
+			t.Errorf("effectiveDBPort(db_port %d, %s) = %d, want %d", tt.dbPort, tt.canonical, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDBVersion(t *testing.T) {
+	tests := []struct {
+		canonical string
+		version   string
+		wantErr   string
+	}{
+		{canonical: "postgresql", version: "16"},
+		{canonical: "postgresql", version: "12", wantErr: `unsupported postgresql db_version "12" (known: 13, 15, 16)`},
+		{canonical: "mysql", version: "8", wantErr: "mysql does not take a db_version"},
+	}
+	for _, tt := range tests {
+		err := validateDBVersion(tt.canonical, tt.version)
+		if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("validateDBVersion(%s, %s) = %v, want %q", tt.canonical, tt.version, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers on a dead-lettered message, next to its original body.
+const (
+	hdrDLQReason          = "Dlq-Reason"
+	hdrDLQOriginalSubject = "Dlq-Original-Subject"
+	hdrDLQWorkerID        = "Dlq-Worker-Id"
+)
+
+// deadLetter forwards a request that failed before it had an id (so its error
+// status can't say which request it was) to DLQ_SUBJECT, if set, with reason.
+func deadLetter(nc *nats.Conn, msg *nats.Msg, reason error) {
+	if cfg.DLQSubject == "" {
+		return
+	}
+	out := nats.NewMsg(cfg.DLQSubject)
+	out.Data = msg.Data
+	out.Header.Set(hdrDLQReason, reason.Error())
+	out.Header.Set(hdrDLQOriginalSubject, msg.Subject)
+	out.Header.Set(hdrDLQWorkerID, cfg.WorkerID)
+	if err := publishOrBuffer(nc, out); err != nil {
+		slog.Warn("dead-letter failed", "subject", cfg.DLQSubject, "err", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestDeadLetter(t *testing.T) {
+	nc := startNATS(t)
+	sub, err := nc.SubscribeSync("test.dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		subject string // DLQ_SUBJECT
+		want    bool
+	}{
+		{name: "unset", subject: ""},
+		{name: "set", subject: "test.dlq", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			cfg.WorkerID, cfg.DLQSubject = "w1", tt.subject
+			msg := &nats.Msg{Subject: "db.install", Data: []byte(`{"id": "7"`)}
+			deadLetter(nc, msg, errors.New("unexpected end of JSON input"))
+			if err := nc.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sub.NextMsg(200 * time.Millisecond)
+			if !tt.want {
+				if err == nil {
+					t.Fatalf("dead-lettered %q with DLQ_SUBJECT unset", got.Data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got.Data) != string(msg.Data) {
+				t.Errorf("data = %q, want %q", got.Data, msg.Data)
+			}
+			h := got.Header
+			if h.Get(hdrDLQReason) != "unexpected end of JSON input" || h.Get(hdrDLQOriginalSubject) != "db.install" || h.Get(hdrDLQWorkerID) != "w1" {
+				t.Errorf("headers %v", h)
+			}
+		})
+	}
+}
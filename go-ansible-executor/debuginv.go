@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretVarRe matches inventory vars whose name mentions a password, with an
+// unquoted or quoted value, e.g. ansible_password=x or db_password="a b".
+var secretVarRe = regexp.MustCompile(`(?i)(\b\w*pass(?:word)?\w*)=("(?:[^"\\]|\\.)*"|'[^']*'|\S+)`)
+
+// secretYAMLVarRe is secretVarRe for a YAML inventory line, e.g. `  db_password: "x"`.
+var secretYAMLVarRe = regexp.MustCompile(`(?im)^(\s*\w*pass(?:word)?\w*:)[ \t]*\S.*$`)
+
+// redactInventory masks the value of every password var in INI or YAML inventory text.
+func redactInventory(inv string) string {
+	inv = secretYAMLVarRe.ReplaceAllString(inv, "$1 ***")
+	return secretVarRe.ReplaceAllString(inv, "$1=***")
+}
+
+// backupInventory keeps a redacted copy of an inventory in dir for debugging
+// connection settings after the original (with secrets) has been deleted.
+func backupInventory(dir, invPath, content string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create debug inventory dir: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(invPath), filepath.Ext(invPath))
+	name := fmt.Sprintf("%s.%s%s", base, time.Now().UTC().Format("20060102T150405Z"), filepath.Ext(invPath))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(redactInventory(content)), 0o600); err != nil {
+		return "", fmt.Errorf("write debug inventory: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactInventory(t *testing.T) {
+	tests := []struct {
+		name string
+		inv  string
+		want string
+	}{
+		{
+			name: "unquoted",
+			inv:  "10.0.0.1 ansible_user=root ansible_password=P@ssw0rd db_name=app db_password=s3cret db_port=5432",
+			want: "10.0.0.1 ansible_user=root ansible_password=*** db_name=app db_password=*** db_port=5432",
+		},
+		{
+			name: "quoted",
+			inv:  `10.0.0.1 ansible_become_password="a \"b\" c" ansible_ssh_pass='x y'`,
+			want: "10.0.0.1 ansible_become_password=*** ansible_ssh_pass=***",
+		},
+		{
+			name: "yaml",
+			inv:  "all:\n  hosts:\n    \"10.0.0.1\":\n      ansible_user: \"root\"\n      ansible_password: \"P@ss: word\"\n      db_port: 5432\n",
+			want: "all:\n  hosts:\n    \"10.0.0.1\":\n      ansible_user: \"root\"\n      ansible_password: ***\n      db_port: 5432\n",
+		},
+		{
+			name: "nothing secret",
+			inv:  "10.0.0.1 ansible_user=root db_port=5432",
+			want: "10.0.0.1 ansible_user=root db_port=5432",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactInventory(tt.inv); got != tt.want {
+				t.Errorf("redactInventory() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugInventoryCopy(t *testing.T) {
+	inTempDir(t)
+	cfg = Config{InventoryDir: "inventories", DebugInventoryDir: filepath.Join(t.TempDir(), "debug")}
+
+	invPath, err := writeInventory(testRequest(), "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeInventory(invPath)
+
+	if _, err := os.Stat(invPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("inventory still there: %v", err)
+	}
+	copies, err := filepath.Glob(filepath.Join(cfg.DebugInventoryDir, "*"))
+	if err != nil || len(copies) != 1 {
+		t.Fatalf("debug copies = %v (%v), want one", copies, err)
+	}
+	data, err := os.ReadFile(copies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"vm-secret", "db-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("copy holds %q:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), `ansible_user="admin"`) {
+		t.Errorf("copy lost the connection settings:\n%s", data)
+	}
+}
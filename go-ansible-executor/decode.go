@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// decodeRequest unmarshals a request message into v. With STRICT_REQUESTS it also
+// rejects fields v doesn't have and anything after the JSON value, so a misspelled
+// field fails up front instead of being silently ignored. Errors name the field.
+func decodeRequest(data []byte, v any) error {
+	if !cfg.StrictRequests {
+		return describeJSONError(json.Unmarshal(data, v))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return describeJSONError(err)
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the request object")
+	}
+	return nil
+}
+
+// describeJSONError rewrites encoding/json's errors to read "<field>: ...".
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Errorf("%s: got JSON %s, want %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this one
+		return fmt.Errorf("%s: unknown field", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		strict  bool
+		wantErr string // empty if it decodes
+	}{
+		{name: "valid", data: `{"id": 7, "db_type": "postgresql"}`},
+		{name: "unknown field", data: `{"id": 7, "db_typ": "postgresql"}`},
+		{name: "unknown field, strict", data: `{"id": 7, "db_typ": "postgresql"}`, strict: true, wantErr: `"db_typ": unknown field`},
+		{name: "wrong type", data: `{"id": "7"}`, wantErr: "id: got JSON string, want int"},
+		{name: "nested wrong type", data: `{"id": 7, "hosts": [{"ip_address": "10.0.0.1", "port": "22"}]}`, wantErr: "port: got JSON string, want int"},
+		{name: "trailing data, strict", data: `{"id": 7} {"id": 8}`, strict: true, wantErr: "unexpected data after the request object"},
+		{name: "not JSON", data: `id=7`, wantErr: "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(s bool) { cfg.StrictRequests = s }(cfg.StrictRequests)
+			cfg.StrictRequests = tt.strict
+			var req InstallRequest
+			err := decodeRequest([]byte(tt.data), &req)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("decodeRequest() = %v", err)
+				}
+				if req.ID != 7 {
+					t.Errorf("id = %d, want 7", req.ID)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeRequest() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
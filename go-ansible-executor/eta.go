@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	etaWindow     = 10 // durations kept per db_type
+	etaMinSamples = 3  // below this the configured default is used
+)
+
+// etaTracker keeps a moving average of successful run durations per canonical db_type.
+// It lives in memory only; a restart starts again from the default.
+type etaTracker struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+}
+
+var etas = &etaTracker{samples: map[string][]time.Duration{}}
+
+// observe records the duration of a successful run.
+func (e *etaTracker) observe(dbType string, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := append(e.samples[dbType], d)
+	if len(s) > etaWindow {
+		s = s[len(s)-etaWindow:]
+	}
+	e.samples[dbType] = s
+}
+
+// estimate returns the average of the recent durations, or def until enough samples exist.
+func (e *etaTracker) estimate(dbType string, def time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.samples[dbType]
+	if len(s) < etaMinSamples {
+		return def
+	}
+	var sum time.Duration
+	for _, d := range s {
+		sum += d
+	}
+	return sum / time.Duration(len(s))
+}
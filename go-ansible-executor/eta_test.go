@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestETAEstimate(t *testing.T) {
+	const def = 7 * time.Minute
+	minutes := func(ms ...int) []time.Duration {
+		var ds []time.Duration
+		for _, m := range ms {
+			ds = append(ds, time.Duration(m)*time.Minute)
+		}
+		return ds
+	}
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{name: "no samples", want: def},
+		{name: "too few samples", samples: minutes(2, 4), want: def},
+		{name: "enough samples", samples: minutes(2, 4, 6), want: 4 * time.Minute},
+		{name: "full window", samples: minutes(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), want: 330 * time.Second},
+		{name: "oldest samples dropped", samples: minutes(100, 100, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10), want: 330 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &etaTracker{samples: map[string][]time.Duration{}}
+			for _, d := range tt.samples {
+				e.observe("postgresql", d)
+			}
+			e.observe("mysql", time.Hour) // other db_types don't count
+			if got := e.estimate("postgresql", def); got != tt.want {
+				t.Errorf("estimate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// factsTimeout bounds the read-only `ansible -m setup` run.
+const factsTimeout = 2 * time.Minute
+
+// FactsRequest asks for a host's facts without installing anything. Only the
+// connection fields of the embedded InstallRequest are used.
+type FactsRequest struct {
+	InstallRequest
+
+	// Optional fact names/globs to keep, e.g. ["ansible_distribution*", "ansible_memtotal_mb"]
+	Filter []string `json:"filter,omitempty"`
+}
+
+// factFilterRe keeps filter entries to plain fact names and globs so they can't
+// break out of the setup module's argument string.
+var factFilterRe = regexp.MustCompile(`^[A-Za-z0-9_*]+$`)
+
+func handleFacts(parent context.Context, nc *nats.Conn, msg *nats.Msg) {
+	runID := newRunID()
+	var req FactsRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		slog.Warn("invalid facts JSON", "err", err)
+		replyFacts(nc, msg, InstallStatus{
+			RunID: runID, Stage: stageFinal, Status: "error",
+			Error: fmt.Sprintf("invalid JSON: %v", err), Category: catClient, Timestamp: time.Now(),
+		})
+		return
+	}
+
+	if err := validateFactsRequest(req); err != nil {
+		slog.Warn("invalid facts request", "id", req.ID, "name", req.Name, "err", err)
+		replyFacts(nc, msg, InstallStatus{
+			ID: req.ID, Name: req.Name, RunID: runID, Stage: stageFinal, Status: "error",
+			Error: err.Error(), Category: catClient, Timestamp: time.Now(),
+		})
+		return
+	}
+
+	// like an install: cancellable through db.install.cancel, one run per VM
+	ctx, untrack := trackRun(parent, req.ID, runID)
+	defer untrack()
+	unlockHosts, err := lockHosts(ctx, req.hostAddresses(), cfg.HostLockWait)
+	if err != nil {
+		category := networkCategory(ctx)
+		if errors.Is(err, errHostBusy) {
+			category = catHostBusy
+		}
+		replyFacts(nc, msg, InstallStatus{
+			ID: req.ID, Name: req.Name, RunID: runID, Stage: stageFinal, Status: "error",
+			Error: err.Error(), Category: category, Timestamp: time.Now(),
+		})
+		return
+	}
+	defer unlockHosts()
+
+	invPath, err := writeInventory(req.InstallRequest, runID)
+	if err != nil {
+		slog.Error("write inventory failed", "id", req.ID, "err", err)
+		replyFacts(nc, msg, InstallStatus{
+			ID: req.ID, Name: req.Name, RunID: runID, Stage: stageFinal, Status: "error",
+			Error: err.Error(), Category: catInternal, Timestamp: time.Now(),
+		})
+		return
+	}
+	defer removeInventory(invPath)
+
+	args := append([]string{"all", "-i", invPath, "-m", "setup"}, req.vaultArgs(invPath)...)
+	if len(req.Filter) > 0 {
+		args = append(args, "-a", "filter="+strings.Join(req.Filter, ","))
+	}
+	var exitCode int
+	var output []byte
+	var runErr error
+	if err := runSlots.do(ctx, req.Priority, func() {
+		exitCode, output, runErr = runAnsible(ctx, ansibleBin(), args, req.hostKeyEnv(), factsTimeout, outputPrefix(req.ID))
+	}); err != nil {
+		runErr = fmt.Errorf("cancelled while waiting for a run slot: %w", context.Cause(ctx))
+	}
+	output = redactSecrets(output, req.secrets())
+
+	st := InstallStatus{
+		ID:              req.ID,
+		Name:            req.Name,
+		RunID:           runID,
+		Stage:           stageFinal,
+		Status:          "success",
+		Inventory:       invPath,
+		AnsibleExitCode: exitCode,
+		ExitReason:      exitReason(exitCode, output),
+		CommandLine:     "ansible " + strings.Join(args, " "),
+		Timestamp:       time.Now(),
+	}
+	facts, parseErr := parseFacts(output)
+	switch {
+	case runErr != nil || exitCode != 0:
+		st.Status = "error"
+		if runErr != nil {
+			st.Error = runErr.Error()
+		}
+		st.AnsibleOutput = truncate(string(output), cfg.MaxOutputBytes)
+		st.Category = playResult{ExitCode: exitCode, Output: output, Err: runErr}.category()
+		st.Facts = facts // of the hosts that did answer, if any
+	case parseErr != nil:
+		st.Status = "error"
+		st.Error = parseErr.Error()
+		st.Category = catInternal
+		st.AnsibleOutput = truncate(string(output), cfg.MaxOutputBytes)
+	default:
+		st.Facts = facts
+	}
+	replyFacts(nc, msg, st)
+}
+
+func validateFactsRequest(r FactsRequest) error {
+	if err := validateTarget(r.InstallRequest); err != nil {
+		return err
+	}
+	if len(r.Hosts) > 0 {
+		return errors.New("facts take ip_address, not hosts")
+	}
+	for _, f := range r.Filter {
+		if !factFilterRe.MatchString(f) {
+			return fmt.Errorf("invalid facts filter %q", f)
+		}
+	}
+	return nil
+}
+
+// factsHeaderRe matches the line starting each host's result in ad-hoc output, e.g.
+// `10.0.0.1 | SUCCESS => {` or `10.0.0.2 | UNREACHABLE! => {`.
+var factsHeaderRe = regexp.MustCompile(`(?m)^(\S+) \| ([A-Z]+)!? => \{`)
+
+// parseFacts pulls each host's ansible_facts out of ad-hoc output like
+// `10.0.0.1 | SUCCESS => { "ansible_facts": {...}, ... }`, keyed by host. Hosts
+// that failed or were unreachable are left out.
+func parseFacts(output []byte) (map[string]any, error) {
+	facts := map[string]any{}
+	for _, m := range factsHeaderRe.FindAllSubmatchIndex(output, -1) {
+		host, result := string(output[m[2]:m[3]]), string(output[m[4]:m[5]])
+		if result != "SUCCESS" {
+			continue
+		}
+		var res struct {
+			Facts map[string]any `json:"ansible_facts"`
+		}
+		if err := json.NewDecoder(bytes.NewReader(output[m[1]-1:])).Decode(&res); err != nil {
+			return nil, fmt.Errorf("parse facts of %s: %w", host, err)
+		}
+		facts[host] = res.Facts
+	}
+	if len(facts) == 0 {
+		return nil, errors.New("no facts found in ansible output")
+	}
+	return facts, nil
+}
+
+// replyFacts publishes the status and, for request/reply callers, answers directly.
+func replyFacts(nc *nats.Conn, msg *nats.Msg, st InstallStatus) {
+	publishStatus(nc, st, msg.Reply)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    map[string]string // host => its ansible_distribution
+		wantErr string
+	}{
+		{
+			name:   "one host",
+			output: "10.0.0.1 | SUCCESS => {\n    \"ansible_facts\": {\"ansible_distribution\": \"Rocky\"},\n    \"changed\": false\n}\n",
+			want:   map[string]string{"10.0.0.1": "Rocky"},
+		},
+		{
+			name: "one unreachable",
+			output: "10.0.0.1 | SUCCESS => {\"ansible_facts\": {\"ansible_distribution\": \"Rocky\"}}\n" +
+				"10.0.0.2 | UNREACHABLE! => {\"changed\": false, \"unreachable\": true}\n" +
+				"10.0.0.3 | SUCCESS => {\"ansible_facts\": {\"ansible_distribution\": \"Debian\"}}\n",
+			want: map[string]string{"10.0.0.1": "Rocky", "10.0.0.3": "Debian"},
+		},
+		{name: "all failed", output: "10.0.0.1 | FAILED! => {\"msg\": \"no python\"}\n", wantErr: "no facts found"},
+		{name: "truncated", output: "10.0.0.1 | SUCCESS => {\"ansible_facts\": {", wantErr: "parse facts of 10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts, err := parseFacts([]byte(tt.output))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(facts) != len(tt.want) {
+				t.Fatalf("facts of %d hosts, want %d: %v", len(facts), len(tt.want), facts)
+			}
+			for host, distro := range tt.want {
+				f, _ := facts[host].(map[string]any)
+				if f["ansible_distribution"] != distro {
+					t.Errorf("%s: facts %v, want ansible_distribution %s", host, facts[host], distro)
+				}
+			}
+		})
+	}
+}
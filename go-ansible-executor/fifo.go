@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var errFIFOUnsupported = errors.New("inventory fifo not supported on this platform")
+
+// fifoStops holds a stop channel per inventory fifo path whose writer is still pending.
+var fifoStops sync.Map
+
+// stopInventoryFIFO tells a pending fifo writer to give up (no-op for regular files).
+func stopInventoryFIFO(path string) {
+	if stop, ok := fifoStops.LoadAndDelete(path); ok {
+		close(stop.(chan struct{}))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// serveInventoryFIFO is unavailable without named pipes; callers fall back to a file.
+func serveInventoryFIFO(path string, data []byte) error {
+	return errFIFOUnsupported
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// serveInventoryFIFO creates a named pipe at path and feeds data to every reader
+// until stopInventoryFIFO, i.e. the run's cleanup.
+//
+// Security rationale: with a regular file the plaintext credentials sit on disk (and
+// in backups/snapshots) for the whole run, and survive a crash. A FIFO has no data
+// blocks: the content only exists in the kernel pipe buffer while ansible reads it,
+// so nothing is left to recover once it has been consumed or the pipe is removed.
+// A run reads the same -i path several times (ansible ping, --syntax-check,
+// retries, one playbook per db_type, verify), so each open gets the whole content.
+func serveInventoryFIFO(path string, data []byte) error {
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		return fmt.Errorf("create inventory fifo: %w", err)
+	}
+	stop := make(chan struct{})
+	fifoStops.Store(path, stop)
+
+	go func() {
+		tick := time.NewTicker(50 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			// O_NONBLOCK: fails with ENXIO until a reader shows up, so cleanup can
+			// always stop us instead of leaving a goroutine blocked in open(2)
+			f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+			if err == nil {
+				if _, err := f.Write(data); err != nil {
+					slog.Warn("write inventory fifo failed", "path", path, "err", err)
+				}
+				// closing gives the reader its EOF; the tick below lets it close its end
+				// before the next open, so it doesn't get the content twice
+				_ = f.Close()
+			} else if !errors.Is(err, syscall.ENXIO) {
+				slog.Warn("open inventory fifo failed", "path", path, "err", err)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return nil
+}
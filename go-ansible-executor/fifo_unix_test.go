@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A run opens its inventory several times; each open must see all of it.
+func TestServeInventoryFIFORereads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vm_7.ini")
+	data := []byte("10.0.0.1 ansible_user=root\n")
+	if err := serveInventoryFIFO(path, data); err != nil {
+		t.Fatal(err)
+	}
+	defer stopInventoryFIFO(path)
+
+	for i := range 3 {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(data) {
+			t.Fatalf("read %d = %q, want %q", i+1, got, data)
+		}
+	}
+}
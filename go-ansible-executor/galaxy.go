@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// galaxyTimeout bounds the startup `ansible-galaxy install` (GALAXY_REQUIREMENTS).
+const galaxyTimeout = 10 * time.Minute
+
+// installGalaxyRequirements runs `ansible-galaxy install -r path`, installing the
+// roles and collections the playbooks need before the worker takes requests. Its
+// output goes to stdout like a playbook's.
+func installGalaxyRequirements(ctx context.Context, path string) error {
+	bin := ansibleTool("ansible-galaxy")
+	slog.Info("installing ansible-galaxy requirements", "bin", bin, "file", path)
+	code, _, err := runAnsible(ctx, bin, []string{"install", "-r", path}, nil, galaxyTimeout, "ansible-galaxy | ")
+	if err != nil {
+		// the output was streamed to stdout already
+		return fmt.Errorf("ansible-galaxy install -r %s (exit %d): %w", path, code, err)
+	}
+	slog.Info("ansible-galaxy requirements installed", "file", path)
+	return nil
+}
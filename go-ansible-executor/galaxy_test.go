@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnsibleTool(t *testing.T) {
+	tests := []struct {
+		playbookBin string
+		want        string
+	}{
+		{playbookBin: "ansible-playbook", want: "ansible-galaxy"},
+		{playbookBin: "/opt/venv/bin/ansible-playbook", want: "/opt/venv/bin/ansible-galaxy"},
+		{playbookBin: "bin/ansible-playbook", want: "bin/ansible-galaxy"},
+	}
+	defer func(b string) { cfg.AnsiblePlaybookBin = b }(cfg.AnsiblePlaybookBin)
+	for _, tt := range tests {
+		cfg.AnsiblePlaybookBin = tt.playbookBin
+		if got := ansibleTool("ansible-galaxy"); got != tt.want {
+			t.Errorf("ANSIBLE_PLAYBOOK_BIN %s: ansibleTool() = %q, want %q", tt.playbookBin, got, tt.want)
+		}
+	}
+}
+
+func TestInstallGalaxyRequirements(t *testing.T) {
+	tests := []struct {
+		name    string
+		exit    string
+		wantErr string
+	}{
+		{name: "installed", exit: "0"},
+		{name: "failed", exit: "1", wantErr: "ansible-galaxy install -r requirements.yml (exit 1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := filepath.Join(dir, "args")
+			script := "#!/bin/sh\necho \"$@\" > " + args + "\nexit " + tt.exit + "\n"
+			if err := os.WriteFile(filepath.Join(dir, "ansible-galaxy"), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			defer func(b string) { cfg.AnsiblePlaybookBin = b }(cfg.AnsiblePlaybookBin)
+			cfg.AnsiblePlaybookBin = filepath.Join(dir, "ansible-playbook")
+
+			err := installGalaxyRequirements(context.Background(), "requirements.yml")
+
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(args); string(got) != "install -r requirements.yml\n" {
+				t.Errorf("ansible-galaxy ran with %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// startHealthServer serves /healthz on addr until ctx is done. It answers 200 once
+// the worker is subscribed and its NATS connection is up, and 503 while starting,
+// disconnected or draining, so a readiness probe takes the pod out of rotation.
+func startHealthServer(ctx context.Context, nc *nats.Conn, addr string, ready *atomic.Bool, draining <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if reason := unhealthy(nc, ready, draining); reason != "" {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("health server failed", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("health check listening", "addr", addr, "path", "/healthz")
+}
+
+// unhealthy says why the worker shouldn't get traffic, or "" if it should.
+func unhealthy(nc *nats.Conn, ready *atomic.Bool, draining <-chan struct{}) string {
+	select {
+	case <-draining:
+		return "draining"
+	default:
+	}
+	if !ready.Load() {
+		return "starting"
+	}
+	if !nc.IsConnected() {
+		return "nats " + nc.Status().String()
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnhealthy(t *testing.T) {
+	nc := startNATS(t)
+	closed := startNATS(t)
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		ready    bool
+		draining bool
+		closed   bool
+		want     string
+	}{
+		{name: "ready", ready: true},
+		{name: "starting", want: "starting"},
+		{name: "draining", ready: true, draining: true, want: "draining"},
+		{name: "draining before ready", draining: true, want: "draining"},
+		{name: "disconnected", ready: true, closed: true, want: "nats CLOSED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ready atomic.Bool
+			ready.Store(tt.ready)
+			draining := make(chan struct{})
+			if tt.draining {
+				close(draining)
+			}
+			conn := nc
+			if tt.closed {
+				conn = closed
+			}
+			if got := unhealthy(conn, &ready, draining); got != tt.want {
+				t.Errorf("unhealthy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
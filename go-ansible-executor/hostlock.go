@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errHostBusy marks a request that gave up waiting for another run on its host.
+var errHostBusy = errors.New("host busy")
+
+// hostLocks allows one run per target host at a time: two playbooks on one VM fight
+// over its package manager lock. Entries go away when nobody holds or waits for them.
+var hostLocks = struct {
+	mu    sync.Mutex
+	hosts map[string]*hostLock
+}{hosts: map[string]*hostLock{}}
+
+type hostLock struct {
+	held chan struct{} // capacity 1: full while a run has the host
+	refs int           // holders and waiters
+}
+
+// lockHosts waits until no other run on this worker targets any of hosts, for at
+// most maxWait (0 waits until ctx is done). Hosts are taken in sorted order, so
+// overlapping multi-host requests can't deadlock. unlock must be called when the run
+// is over.
+func lockHosts(ctx context.Context, hosts []string, maxWait time.Duration) (unlock func(), err error) {
+	hosts = slices.Clone(hosts)
+	slices.Sort(hosts)
+	hosts = slices.Compact(hosts)
+
+	waitCtx := ctx
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+	var locked []string
+	unlock = func() {
+		for _, h := range locked {
+			releaseHost(h)
+		}
+	}
+	for _, h := range hosts {
+		if err := acquireHost(waitCtx, h); err != nil {
+			unlock()
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("cancelled while waiting for %s: %w", h, context.Cause(ctx))
+			}
+			return nil, fmt.Errorf("%w: %s still has another run after %s", errHostBusy, h, maxWait)
+		}
+		locked = append(locked, h)
+	}
+	return unlock, nil
+}
+
+func acquireHost(ctx context.Context, host string) error {
+	hostLocks.mu.Lock()
+	l := hostLocks.hosts[host]
+	if l == nil {
+		l = &hostLock{held: make(chan struct{}, 1)}
+		hostLocks.hosts[host] = l
+	}
+	l.refs++
+	hostLocks.mu.Unlock()
+
+	select {
+	case l.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		unrefHost(host, l)
+		return ctx.Err()
+	}
+}
+
+func releaseHost(host string) {
+	hostLocks.mu.Lock()
+	l := hostLocks.hosts[host]
+	hostLocks.mu.Unlock()
+	<-l.held
+	unrefHost(host, l)
+}
+
+func unrefHost(host string, l *hostLock) {
+	hostLocks.mu.Lock()
+	defer hostLocks.mu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(hostLocks.hosts, host)
+	}
+}
+
+// hostAddresses are the address ansible connects to for every target of the
+// request, as lockHosts keys: two ip_address aliases behind one connect_address
+// are the same VM.
+func (r InstallRequest) hostAddresses() []string {
+	var addrs []string
+	for _, t := range r.targets() {
+		addrs = append(addrs, inventoryHost(cmp.Or(t.ConnectAddress, t.IPAddress)))
+	}
+	return addrs
+}
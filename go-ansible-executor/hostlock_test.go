@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestLockHosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		held     []string // locked by the first run
+		hosts    []string // wanted by the second
+		maxWait  time.Duration
+		cancel   bool // cancel the second run while it waits
+		wantErr  error
+		wantWait bool // the second run only gets in once the first unlocks
+	}{
+		{name: "other host", held: []string{"10.0.0.1"}, hosts: []string{"10.0.0.2"}},
+		{name: "same host waits", held: []string{"10.0.0.1"}, hosts: []string{"10.0.0.1"}, wantWait: true},
+		{name: "overlapping hosts wait", held: []string{"10.0.0.2", "10.0.0.1"}, hosts: []string{"10.0.0.3", "10.0.0.1"}, wantWait: true},
+		{name: "same host busy", held: []string{"10.0.0.1"}, hosts: []string{"10.0.0.1"}, maxWait: 50 * time.Millisecond, wantErr: errHostBusy},
+		{name: "cancelled while waiting", held: []string{"10.0.0.1"}, hosts: []string{"10.0.0.1"}, cancel: true, wantErr: errCancelled},
+		{name: "duplicate hosts", hosts: []string{"10.0.0.1", "10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlockFirst, err := lockHosts(context.Background(), tt.held, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			type result struct {
+				unlock func()
+				err    error
+			}
+			got := make(chan result, 1)
+			go func() {
+				unlock, err := lockHosts(ctx, tt.hosts, tt.maxWait)
+				got <- result{unlock, err}
+			}()
+
+			if tt.wantWait || tt.cancel {
+				select {
+				case r := <-got:
+					t.Fatalf("second run got in while the first held its host (err %v)", r.err)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			if tt.cancel {
+				cancel(errCancelled)
+			}
+			if tt.wantWait {
+				unlockFirst()
+			}
+			var r result
+			select {
+			case r = <-got:
+			case <-time.After(time.Second):
+				t.Fatal("second run still waiting")
+			}
+			if !errors.Is(r.err, tt.wantErr) {
+				t.Fatalf("lockHosts() = %v, want %v", r.err, tt.wantErr)
+			}
+			if r.err == nil {
+				r.unlock()
+			}
+			if !tt.wantWait {
+				unlockFirst()
+			}
+
+			hostLocks.mu.Lock()
+			defer hostLocks.mu.Unlock()
+			if len(hostLocks.hosts) != 0 {
+				t.Errorf("host locks left: %v", hostLocks.hosts)
+			}
+		})
+	}
+}
+
+// heldRunner holds every run until release is closed.
+type heldRunner struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *heldRunner) Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (int, []byte, error) {
+	r.started <- struct{}{}
+	<-r.release
+	return 0, nil, nil
+}
+
+// Two requests for one VM: the second waits for the first and gives up as host_busy.
+func TestHandleMessageSameHost(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  [2]string // ip_address of each request; both connect through 127.0.0.1
+	}{
+		{name: "same ip_address", ips: [2]string{"10.0.0.2", "10.0.0.2"}},
+		{name: "aliases of one connect_address", ips: [2]string{"10.0.0.2", "10.0.0.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			cfg.HostLockWait = 100 * time.Millisecond
+			defer func(d time.Duration) { startDelay = d }(startDelay)
+			startDelay = 0
+			nc := startNATS(t)
+			sub, err := nc.SubscribeSync(cfg.SubjectStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := fakeSSH(t)
+			runner := &heldRunner{started: make(chan struct{}, 2), release: make(chan struct{})}
+
+			handle := func(id int) chan struct{} {
+				req := testRequest()
+				req.ID = id
+				req.IPAddress, req.ConnectAddress, req.Port = tt.ips[id-1], "127.0.0.1", port
+				data, _ := json.Marshal(req)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					handleMessage(context.Background(), nc, runner, &nats.Msg{Subject: cfg.SubjectInstall, Data: data})
+				}()
+				return done
+			}
+			first := handle(1)
+			<-runner.started
+			select {
+			case <-handle(2):
+			case <-time.After(5 * time.Second):
+				close(runner.release)
+				t.Fatal("second run still waiting after HOST_LOCK_WAIT")
+			}
+			close(runner.release)
+			<-first
+
+			finals := map[int]InstallStatus{}
+			for len(finals) < 2 {
+				msg, err := sub.NextMsg(5 * time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var st InstallStatus
+				if err := json.Unmarshal(msg.Data, &st); err != nil {
+					t.Fatal(err)
+				}
+				if st.Stage == stageFinal {
+					finals[st.ID] = st
+				}
+			}
+			if st := finals[1]; st.Status != "success" {
+				t.Errorf("first run: %s %q, want success", st.Status, st.Error)
+			}
+			if st := finals[2]; st.Status != "error" || st.Category != catHostBusy || st.ErrorKind != kindHostBusy {
+				t.Errorf("second run: %s %s/%s %q, want an error with category %s and error_kind %s",
+					st.Status, st.Category, st.ErrorKind, st.Error, catHostBusy, kindHostBusy)
+			}
+			if len(runner.started) != 0 {
+				t.Error("the second run started a playbook")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+)
+
+// HostSpec is one VM of a multi-host request. All hosts share the request's
+// credentials and are installed by the same playbook run.
+type HostSpec struct {
+	IPAddress      string `json:"ip_address"`
+	ConnectAddress string `json:"connect_address,omitempty"`
+	Port           int    `json:"port,omitempty"`
+}
+
+// targets lists the request's hosts: hosts if given, else the single ip_address.
+func (r InstallRequest) targets() []HostSpec {
+	if len(r.Hosts) > 0 {
+		return r.Hosts
+	}
+	return []HostSpec{{IPAddress: r.IPAddress, ConnectAddress: r.ConnectAddress, Port: r.Port}}
+}
+
+// forHost is the request narrowed to one of its targets, so the per-host helpers
+// (sshAddress, inventoryVars, ...) apply to it.
+func (r InstallRequest) forHost(h HostSpec) InstallRequest {
+	r.IPAddress, r.ConnectAddress, r.Port = h.IPAddress, h.ConnectAddress, h.Port
+	r.Hosts = nil
+	return r
+}
+
+// sshHop is an address and port the worker dials itself.
+type sshHop struct {
+	addr string
+	port int
+}
+
+// firstHops lists what the worker itself connects to on the way to the VMs: the
+// bastion if there is one, else each VM's SSH address and port.
+func (r InstallRequest) firstHops() []sshHop {
+	if r.BastionHost != "" {
+		return []sshHop{{r.BastionHost, cmp.Or(r.BastionPort, defaultSSHPort)}}
+	}
+	var hops []sshHop
+	for _, t := range r.targets() {
+		h := r.forHost(t)
+		hops = append(hops, sshHop{h.sshAddress(), h.sshPort()})
+	}
+	return hops
+}
+
+// validateTargets checks the address and port of every host. Two hosts may not
+// end up as the same inventory host.
+func validateTargets(r InstallRequest) error {
+	if len(r.Hosts) > 0 && (r.IPAddress != "" || r.ConnectAddress != "" || r.Port != 0) {
+		return errors.New("set either ip_address (with connect_address and port) or hosts, not both")
+	}
+	seen := map[string]bool{}
+	for i, h := range r.targets() {
+		err := validateHostSpec(h)
+		if err == nil && seen[inventoryHost(h.IPAddress)] {
+			err = fmt.Errorf("duplicate ip_address %q", h.IPAddress)
+		}
+		if err != nil {
+			if len(r.Hosts) > 0 {
+				return fmt.Errorf("hosts[%d]: %w", i, err)
+			}
+			return err
+		}
+		seen[inventoryHost(h.IPAddress)] = true
+	}
+	return nil
+}
+
+func validateHostSpec(h HostSpec) error {
+	if err := validateHost(h.IPAddress); err != nil {
+		return fmt.Errorf("invalid ip_address: %v", err)
+	}
+	if h.ConnectAddress != "" {
+		if err := validateHost(h.ConnectAddress); err != nil {
+			return fmt.Errorf("invalid connect_address: %v", err)
+		}
+	}
+	if h.Port != 0 && (h.Port < 1 || h.Port > 65535) {
+		return fmt.Errorf("invalid port %d (must be 1-65535)", h.Port)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFirstHops(t *testing.T) {
+	hosts := []HostSpec{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2", ConnectAddress: "203.0.113.10", Port: 2222}}
+	tests := []struct {
+		name string
+		req  InstallRequest
+		want []string
+	}{
+		{name: "single host", req: InstallRequest{IPAddress: "10.0.0.1", Port: 2200}, want: []string{"10.0.0.1:2200"}},
+		{name: "hosts", req: InstallRequest{Hosts: hosts}, want: []string{"10.0.0.1:22", "203.0.113.10:2222"}},
+		{name: "hosts behind a bastion", req: InstallRequest{Hosts: hosts, BastionHost: "jump"}, want: []string{"jump:22"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, hop := range tt.req.firstHops() {
+				got = append(got, net.JoinHostPort(hop.addr, strconv.Itoa(hop.port)))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("firstHops() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderInventoryHosts(t *testing.T) {
+	setupWorker(t)
+	req := testRequest()
+	req.IPAddress = ""
+	req.Hosts = []HostSpec{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2", ConnectAddress: "203.0.113.10", Port: 2222}}
+
+	for _, format := range []string{"ini", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			defer func(f string) { cfg.InventoryFormat = f }(cfg.InventoryFormat)
+			cfg.InventoryFormat = format
+			content, names := renderInventory(req, "inventories/vm_7.ini")
+
+			if n := strings.Count(content, "ansible_user"); n != 2 {
+				t.Errorf("%d hosts set ansible_user, want 2:\n%s", n, content)
+			}
+			for _, want := range []string{"10.0.0.1", "10.0.0.2", "203.0.113.10", "2222"} {
+				if !strings.Contains(content, want) {
+					t.Errorf("inventory lacks %q:\n%s", want, content)
+				}
+			}
+			if format == "yaml" && strings.Count(content, "all:") != 1 {
+				t.Errorf("want one all: group:\n%s", content)
+			}
+			if i := slices.Index(names, "ansible_port"); i < 0 || slices.Index(names[i+1:], "ansible_port") >= 0 {
+				t.Errorf("names = %v, want ansible_port once", names)
+			}
+		})
+	}
+}
+
+func TestApplyResultsHostResults(t *testing.T) {
+	out := func(failed int) []byte {
+		return []byte("PLAY RECAP\n10.0.0.1 : ok=2 changed=1 failed=0\n10.0.0.2 : ok=1 changed=0 failed=" + strconv.Itoa(failed) + "\n")
+	}
+	req := testRequest()
+	req.IPAddress = ""
+	req.Hosts = []HostSpec{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.2"}}
+
+	var st InstallStatus
+	applyResults(&st, req, []playResult{{DBType: "postgresql", Output: out(0)}, {DBType: "mysql", Output: out(1)}})
+	want := map[string]map[string]int{
+		"10.0.0.1": {"ok": 4, "changed": 2, "failed": 0},
+		"10.0.0.2": {"ok": 2, "changed": 0, "failed": 1},
+	}
+	if !maps.EqualFunc(st.HostResults, want, maps.Equal) {
+		t.Errorf("host_results = %v, want %v", st.HostResults, want)
+	}
+
+	st = InstallStatus{}
+	applyResults(&st, testRequest(), []playResult{{DBType: "postgresql", Output: out(0)}})
+	if st.HostResults != nil {
+		t.Errorf("single-host request got host_results %v", st.HostResults)
+	}
+}
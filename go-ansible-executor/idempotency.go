@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKey bounds the idempotency_key a caller may send.
+const maxIdempotencyKey = 256
+
+// idemEntry is a claimed idempotency key: the run holding it and, once that run
+// is over, its final status.
+type idemEntry struct {
+	runID   string
+	done    bool
+	final   InstallStatus
+	expires time.Time // zero while the run is in progress
+}
+
+// idempotencyKeys remembers recently handled idempotency keys on this worker. A
+// duplicate that lands on another worker is not caught.
+var idempotencyKeys = struct {
+	mu      sync.Mutex
+	entries map[string]*idemEntry
+}{entries: map[string]*idemEntry{}}
+
+// claimIdempotencyKey claims key for runID. If the key is taken and not expired, it
+// returns the holder's entry instead and ok is false.
+func claimIdempotencyKey(key, runID string) (prior idemEntry, ok bool) {
+	idempotencyKeys.mu.Lock()
+	defer idempotencyKeys.mu.Unlock()
+	now := time.Now()
+	for k, e := range idempotencyKeys.entries {
+		if e.done && now.After(e.expires) {
+			delete(idempotencyKeys.entries, k)
+		}
+	}
+	if e := idempotencyKeys.entries[key]; e != nil {
+		return *e, false
+	}
+	idempotencyKeys.entries[key] = &idemEntry{runID: runID}
+	return idemEntry{}, true
+}
+
+// releaseIdempotencyKey ends runID's claim on key. With keep, duplicates get final
+// for the next IDEMPOTENCY_TTL; otherwise the key is free again at once, e.g. for
+// a redelivery meant to retry the run.
+func releaseIdempotencyKey(key, runID string, final InstallStatus, keep bool) {
+	idempotencyKeys.mu.Lock()
+	defer idempotencyKeys.mu.Unlock()
+	e := idempotencyKeys.entries[key]
+	if e == nil || e.runID != runID {
+		return
+	}
+	if !keep {
+		delete(idempotencyKeys.entries, key)
+		return
+	}
+	e.done, e.final, e.expires = true, final, time.Now().Add(cfg.IdempotencyTTL)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	final := InstallStatus{ID: 7, Status: "success", RunID: "run1"}
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		release  func(key string) // after run1 claimed key
+		wantOK   bool             // run2 may claim the key
+		wantDone bool
+	}{
+		{name: "in progress", release: func(string) {}},
+		{name: "done", ttl: time.Hour, release: func(k string) { releaseIdempotencyKey(k, "run1", final, true) }, wantDone: true},
+		{name: "expired", ttl: -time.Second, release: func(k string) { releaseIdempotencyKey(k, "run1", final, true) }, wantOK: true},
+		{name: "released for a retry", ttl: time.Hour, release: func(k string) { releaseIdempotencyKey(k, "run1", final, false) }, wantOK: true},
+		{name: "released by another run", ttl: time.Hour, release: func(k string) { releaseIdempotencyKey(k, "run9", final, false) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(d time.Duration) { cfg.IdempotencyTTL = d }(cfg.IdempotencyTTL)
+			cfg.IdempotencyTTL = tt.ttl
+			key := "key " + tt.name
+			if _, ok := claimIdempotencyKey(key, "run1"); !ok {
+				t.Fatal("first claim failed")
+			}
+			tt.release(key)
+
+			prior, ok := claimIdempotencyKey(key, "run2")
+			if ok != tt.wantOK {
+				t.Fatalf("second claim ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok {
+				return
+			}
+			if prior.runID != "run1" || prior.done != tt.wantDone {
+				t.Errorf("prior = run %s, done %v; want run1, %v", prior.runID, prior.done, tt.wantDone)
+			}
+			if tt.wantDone && prior.final.Status != final.Status {
+				t.Errorf("prior final = %+v, want %+v", prior.final, final)
+			}
+		})
+	}
+}
+
+// A replayed final status was counted when its install ran.
+func TestIdempotencyReplayNotCounted(t *testing.T) {
+	setupWorker(t)
+	defer func(d time.Duration) { startDelay = d }(startDelay)
+	startDelay = 0
+	cfg.IdempotencyTTL = time.Hour
+	nc := startNATS(t)
+	metrics.mu.Lock()
+	metrics.installs = map[string]uint64{}
+	metrics.mu.Unlock()
+
+	req := testRequest()
+	req.ConnectAddress, req.Port = "127.0.0.1", fakeSSH(t)
+	req.IdempotencyKey = "replay-not-counted"
+	data, _ := json.Marshal(req)
+	runner := &fakeRunner{}
+	for range 2 {
+		handleMessage(context.Background(), nc, runner, &nats.Msg{Subject: cfg.SubjectInstall, Data: data})
+	}
+
+	if n := len(runner.runs()); n != 1 {
+		t.Fatalf("%d playbook runs, want 1", n)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if n := metrics.installs["success"]; n != 1 {
+		t.Errorf("installs_total{status=\"success\"} = %d, want 1", n)
+	}
+}
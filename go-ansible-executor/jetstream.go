@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// subscribeInstallJetStream consumes db.install from a durable JetStream consumer,
+// creating the stream if it doesn't exist yet. Messages are acked by handleMessage
+// (see delivery), so a request survives a worker restart or crash.
+func subscribeInstallJetStream(nc *nats.Conn, cb nats.MsgHandler) (*nats.Subscription, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := js.StreamInfo(cfg.JetStreamStream); errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err := js.AddStream(&nats.StreamConfig{
+			Name:     cfg.JetStreamStream,
+			Subjects: []string{cfg.SubjectInstall},
+		}); err != nil {
+			return nil, fmt.Errorf("create stream %s: %w", cfg.JetStreamStream, err)
+		}
+		slog.Info("created JetStream stream", "stream", cfg.JetStreamStream, "subject", cfg.SubjectInstall)
+	} else if err != nil {
+		return nil, fmt.Errorf("stream %s: %w", cfg.JetStreamStream, err)
+	}
+
+	// Create the consumer ourselves and bind to it: a consumer the client library
+	// creates is deleted again on Unsubscribe, which would lose the durable's state.
+	if _, err := js.ConsumerInfo(cfg.JetStreamStream, cfg.JetStreamDurable); errors.Is(err, nats.ErrConsumerNotFound) {
+		if _, err := js.AddConsumer(cfg.JetStreamStream, &nats.ConsumerConfig{
+			Durable:        cfg.JetStreamDurable,
+			DeliverSubject: nats.NewInbox(),
+			DeliverGroup:   cfg.QueueGroup,
+			FilterSubject:  cfg.SubjectInstall,
+			AckPolicy:      nats.AckExplicitPolicy,
+			AckWait:        cfg.JetStreamAckWait,
+			MaxDeliver:     cfg.JetStreamMaxDeliver,
+		}); err != nil {
+			return nil, fmt.Errorf("create consumer %s: %w", cfg.JetStreamDurable, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("consumer %s: %w", cfg.JetStreamDurable, err)
+	}
+
+	return js.QueueSubscribe(cfg.SubjectInstall, cfg.QueueGroup, cb,
+		nats.Bind(cfg.JetStreamStream, cfg.JetStreamDurable),
+		nats.ManualAck(),
+	)
+}
+
+// delivery settles one db.install message. Everything is a no-op for core NATS
+// messages, which can't be acked.
+type delivery struct {
+	msg *nats.Msg
+	js  bool
+}
+
+func newDelivery(msg *nats.Msg) delivery {
+	return delivery{msg: msg, js: cfg.JetStream && msg.Reply != ""}
+}
+
+// keepAlive tells the server the message is still being worked on, so a run longer
+// than the ack wait isn't redelivered to another worker. Call the returned stop
+// before settling.
+func (d delivery) keepAlive() (stop func()) {
+	if !d.js {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(max(cfg.JetStreamAckWait/2, time.Second))
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if err := d.msg.InProgress(); err != nil {
+					slog.Warn("jetstream in-progress failed", "err", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// settle acks a handled message once its final status is out. Requests that can
+// never succeed (CLIENT errors) are terminated; retry or a shutdown mid-request
+// Naks it for redelivery, up to JETSTREAM_MAX_DELIVER attempts.
+func (d delivery) settle(ctx context.Context, final InstallStatus, retry bool) {
+	if !d.js {
+		return
+	}
+	var err error
+	switch {
+	case ctx.Err() != nil:
+		err = d.msg.Nak()
+	case retry:
+		err = d.msg.NakWithDelay(cfg.JetStreamNakDelay)
+	case final.Category == catClient || final.Category == catCancelled:
+		err = d.msg.Term()
+	default:
+		err = d.msg.Ack()
+	}
+	if err != nil {
+		slog.Warn("jetstream settle failed", "id", final.ID, "err", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestJetStreamSettle(t *testing.T) {
+	tests := []struct {
+		name          string
+		final         InstallStatus
+		retry         bool
+		wantRedeliver bool
+	}{
+		{name: "success is acked", final: InstallStatus{Status: "success"}},
+		{name: "client error is terminated", final: InstallStatus{Status: "error", Category: catClient}},
+		{name: "playbook failure is acked", final: InstallStatus{Status: "error", Category: catPlaybook}},
+		{name: "retry is redelivered", final: InstallStatus{Status: "error", Category: catNetwork}, retry: true, wantRedeliver: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			cfg.JetStream = true
+			cfg.JetStreamNakDelay = 10 * time.Millisecond
+			nc := startNATS(t, func(o *server.Options) { o.JetStream, o.StoreDir = true, t.TempDir() })
+
+			msgs := make(chan *nats.Msg, 4)
+			sub, err := subscribeInstallJetStream(nc, func(msg *nats.Msg) { msgs <- msg })
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+			if err := nc.Publish(cfg.SubjectInstall, []byte(`{"id": 7}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			var msg *nats.Msg
+			select {
+			case msg = <-msgs:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no delivery")
+			}
+			newDelivery(msg).settle(context.Background(), tt.final, tt.retry)
+
+			redelivered := false
+			select {
+			case <-msgs:
+				redelivered = true
+			case <-time.After(500 * time.Millisecond):
+			}
+			if redelivered != tt.wantRedeliver {
+				t.Errorf("redelivered = %v, want %v", redelivered, tt.wantRedeliver)
+			}
+		})
+	}
+}
+
+func TestSubscribeInstallJetStreamKeepsDurable(t *testing.T) {
+	setupWorker(t)
+	cfg.JetStream = true
+	nc := startNATS(t, func(o *server.Options) { o.JetStream, o.StoreDir = true, t.TempDir() })
+
+	sub, err := subscribeInstallJetStream(nc, func(*nats.Msg) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	js, _ := nc.JetStream()
+	if _, err := js.ConsumerInfo(cfg.JetStreamStream, cfg.JetStreamDurable); err != nil {
+		t.Fatalf("durable gone after Unsubscribe: %v", err)
+	}
+	// a restarted worker binds to the same durable
+	sub, err = subscribeInstallJetStream(nc, func(*nats.Msg) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+}
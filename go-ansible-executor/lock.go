@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var errLocked = errors.New("lock held by another process")
+
+// lockRetryInterval is how often a standby instance retries the lock.
+const lockRetryInterval = 5 * time.Second
+
+// acquireInstanceLock keeps two workers on one node from sharing INVENTORY_DIR.
+// In "exit" mode a held lock is an error; in "standby" mode we wait until the
+// other instance goes away. Close the returned file on shutdown to release it.
+func acquireInstanceLock(ctx context.Context, path, mode string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create lock dir: %w", err)
+	}
+	logged := false
+	for {
+		f, err := tryLockFile(path)
+		if err == nil {
+			if f != nil {
+				// record the holder for whoever finds the lock taken
+				_ = f.Truncate(0)
+				_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+				slog.Info("acquired instance lock", "path", path)
+			}
+			return f, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, err
+		}
+		if mode != "standby" {
+			return nil, fmt.Errorf("another ansible-executor holds %s; stop it or set LOCK_MODE=standby", path)
+		}
+		if !logged {
+			slog.Info("instance lock held by another worker, standing by", "path", path)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// tryLockFile is a no-op where flock isn't available.
+func tryLockFile(path string) (*os.File, error) {
+	slog.Warn("instance lock not supported on this platform, continuing without it")
+	return nil, nil
+}
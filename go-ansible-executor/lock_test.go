@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstanceLockContended(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "executor.lock")
+	held, err := acquireInstanceLock(context.Background(), path, "exit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want our pid", data)
+	}
+
+	tests := []struct {
+		mode    string
+		wantErr func(error) bool
+	}{
+		{"exit", func(err error) bool { return err != nil && strings.Contains(err.Error(), "LOCK_MODE=standby") }},
+		{"standby", func(err error) bool { return errors.Is(err, context.DeadlineExceeded) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			f, err := acquireInstanceLock(ctx, path, tt.mode)
+			if f != nil {
+				f.Close()
+			}
+			if !tt.wantErr(err) {
+				t.Errorf("second acquire in %s mode = %v", tt.mode, err)
+			}
+		})
+	}
+
+	// once the holder lets go, the next instance gets it
+	held.Close()
+	f, err := acquireInstanceLock(context.Background(), path, "exit")
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// envLogFormat and envLogLevel read LOG_FORMAT and LOG_LEVEL. main needs them
+// before loadConfig, which may already log warnings.
+func envLogFormat() string { return envChoice("LOG_FORMAT", "text", "json") }
+func envLogLevel() string  { return envChoice("LOG_LEVEL", "info", "debug", "warn", "error") }
+
+// setupLogging makes the default slog logger write to stderr in format at level.
+// Output of the log package (e.g. from libraries) goes there too, at info.
+func setupLogging(format, level string) {
+	var lvl slog.Level
+	_ = lvl.UnmarshalText([]byte(level)) // envChoice already limited it to known levels
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// prefixWriter writes prefix at the start of every line passed through to w.
+// Used on the journald leg only, so captured output stays unprefixed.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool // the last write did not end with a newline
+}
+
+// outputPrefix is the per-line tag for a request's streamed ansible output, e.g.
+// "db-installer id=42 | ". Empty when LOG_IDENTIFIER is unset.
+func outputPrefix(id int) string {
+	if cfg.LogIdentifier == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s id=%d | ", cfg.LogIdentifier, id)
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var out []byte
+	for len(b) > 0 {
+		if !p.midLine {
+			out = append(out, p.prefix...)
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			out = append(out, b...)
+			p.midLine = true
+			break
+		}
+		out = append(out, b[:i+1]...)
+		b = b[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
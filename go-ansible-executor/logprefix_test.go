@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{name: "whole lines", writes: []string{"a\nb\n"}, want: "P a\nP b\n"},
+		{name: "line split across writes", writes: []string{"TASK [x", "] ***\nok: [h]\n"}, want: "P TASK [x] ***\nP ok: [h]\n"},
+		{name: "newline on its own", writes: []string{"a", "\n", "b\n"}, want: "P a\nP b\n"},
+		{name: "unterminated last line", writes: []string{"a\nb"}, want: "P a\nP b"},
+		{name: "blank lines", writes: []string{"\n\n"}, want: "P \nP \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &prefixWriter{w: &out, prefix: []byte("P ")}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if out.String() != tt.want {
+				t.Errorf("wrote %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAnsiblePrefixesStdoutOnly(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	defer func(id string) { cfg.LogIdentifier = id }(cfg.LogIdentifier)
+	cfg.LogIdentifier = "db-installer"
+
+	// capture what the worker streams to journald
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = w
+	streamed := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		streamed <- b
+	}()
+
+	_, output, err := runAnsible(context.Background(), sh, []string{"-c", `printf 'PLAY [db]\nok: [10.0.0.1]\n'; echo warn >&2`}, nil, time.Minute, outputPrefix(42))
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "PLAY [db]\nok: [10.0.0.1]\nwarn\n"; string(output) != want {
+		t.Errorf("captured %q, want %q", output, want)
+	}
+	want := "db-installer id=42 | PLAY [db]\ndb-installer id=42 | ok: [10.0.0.1]\ndb-installer id=42 | warn\n"
+	if got := <-streamed; string(got) != want {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+}
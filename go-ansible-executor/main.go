@@ -2,21 +2,30 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	mrand "math/rand/v2"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"net/netip"
 	"os/exec"
@@ -25,19 +34,50 @@ import (
 )
 
 const (
-	subjectInstall       = "db.install"
-	subjectInstallStatus = "db.install.status"
-	defaultNatsURL       = "nats://127.0.0.1:4222"
+	defaultSubjectInstall  = "db.install"
+	defaultSubjectStatus   = "db.install.status"
+	subjectReloadPlaybooks = "db.install.reload.playbooks"
+	subjectFacts           = "db.install.facts"
+	subjectLogChunk        = "db.install.log.chunk"
+	subjectCancel          = "db.install.cancel"
+	subjectConfig          = "db.install.config"
+	defaultNatsURL         = "nats://127.0.0.1:4222"
 
-	inventoryDir = "inventories"
+	// queue group (and JetStream deliver group) shared by all workers
+	defaultQueueGroup = "db-install-workers"
+
+	defaultSSHPort = 22
+
+	// ENOSPC while writing an inventory: sweep and retry this often before failing
+	diskFullRetries = 3
+
+	// upper bound of the doubling RETRY_BACKOFF between playbook retries
+	maxRetryBackoff = 10 * time.Minute
 
 	// Adjust if you want a different play timeout
-	playTimeout = 30 * time.Minute
+	playTimeout    = 30 * time.Minute
+	maxPlayTimeout = 2 * time.Hour // upper bound for a request's timeout_seconds
+
+	// how often a heartbeat status is published while a playbook runs
+	heartbeatInterval = 30 * time.Second
 
-	// Limit published ansible output size
-	maxOutputBytes = 10000
+	// Default limit of published ansible output size (MAX_OUTPUT_BYTES)
+	defaultMaxOutputBytes = 10000
 )
 
+// diskFullRetryDelay is the pause between inventory writes on ENOSPC
+var diskFullRetryDelay = 10 * time.Second
+
+// createFile opens the files writeNewFile writes; tests swap it to fill the disk
+var createFile = os.OpenFile
+
+// ansibleWaitDelay is how long an ansible CLI's output pipes may stay open after
+// it was killed or exited.
+const ansibleWaitDelay = 5 * time.Second
+
+// startDelay is the pause before a request is decoded; a var so tests can skip it
+var startDelay = 10 * time.Second
+
 type InstallRequest struct {
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
@@ -48,199 +88,1377 @@ type InstallRequest struct {
 	DBUser     string `json:"db_user"`
 	DBPassword string `json:"db_password"`
 	DBName     string `json:"db_name"`
+
+	// Optional PEM private key; used instead of vm_password for SSH when set
+	SSHPrivateKey string `json:"ssh_private_key,omitempty"`
+
+	// Optional sudo password for a non-root vm_user; empty means passwordless sudo
+	BecomePassword string `json:"become_password,omitempty"`
+
+	// Optional password for the vault-encrypted values in the request (see vault.go);
+	// ANSIBLE_VAULT_PASSWORD_FILE is used when empty
+	VaultPassword string `json:"vault_password,omitempty"`
+
+	// Optional escalation with become_password: "sudo" (default), "su" or "doas"
+	BecomeMethod string `json:"become_method,omitempty"`
+
+	// Optional known_hosts lines for the target; when set, its host key is verified
+	// against them instead of being accepted blindly
+	KnownHosts string `json:"known_hosts,omitempty"`
+
+	// Optional SSH port (ansible_port); 0 means the default 22
+	Port int `json:"port,omitempty"`
+
+	// Optional list of identical VMs to install in one playbook run, instead of
+	// ip_address, connect_address and port
+	Hosts []HostSpec `json:"hosts,omitempty"`
+
+	// Optional: install hosts one at a time, each with its own playbook run and
+	// inventory, instead of all in one run (see runBatch)
+	Batch bool `json:"batch,omitempty"`
+
+	// Optional: run the batch's first host alone first, and the others only if it
+	// succeeds. Implies Batch.
+	CanaryFirst bool `json:"canary_first,omitempty"`
+
+	// Optional: run the batch's hosts (after the canary, with CanaryFirst) at the
+	// same time instead of one by one, as run slots allow. Implies Batch.
+	ParallelHosts bool `json:"parallel_hosts,omitempty"`
+
+	// Optional bastion the VM is reached through (an ssh ProxyCommand). The worker
+	// logs into it with its own SSH identity, as bastion_user or its own user.
+	BastionHost string `json:"bastion_host,omitempty"`
+	BastionUser string `json:"bastion_user,omitempty"`
+	BastionPort int    `json:"bastion_port,omitempty"` // 0 means 22
+
+	// Optional extra ssh options for the VMs, e.g. "-o ServerAliveInterval=30",
+	// appended to ansible_ssh_common_args (see validateSSHCommonArgs)
+	SSHCommonArgs string `json:"ssh_common_args,omitempty"`
+
+	// Optional playbook timeout in seconds; 0 uses playTimeout, capped at maxPlayTimeout
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// Optional list of db_types to install on the same host, instead of db_type.
+	// They run one after another, or concurrently when Parallel is set.
+	DBTypes  []string `json:"db_types,omitempty"`
+	Parallel bool     `json:"parallel,omitempty"`
+
+	// Optional port the database is set up to listen on; defaults per engine (see
+	// defaultDBPorts)
+	DBPort int `json:"db_port,omitempty"`
+
+	// Optional engine version (see knownDBVersions), passed as db_version; the
+	// same as a db_type suffix. Empty uses the playbook's default.
+	DBVersion string `json:"db_version,omitempty"`
+
+	// Optional routable address (e.g. NAT/public IP) when it differs from ip_address,
+	// which then only serves as the host's logical inventory name
+	ConnectAddress string `json:"connect_address,omitempty"`
+
+	// Optional ansible-playbook flags, each must be on the ALLOWED_EXTRA_ARGS allowlist
+	ExtraArgs []string `json:"extra_args,omitempty"`
+
+	// Optional 0..9, higher-priority requests get a run slot first when saturated
+	Priority int `json:"priority,omitempty"`
+
+	// Optional OS family (see validOSFamilies) to pick an OS-specific playbook
+	OSFamily string `json:"os_family,omitempty"`
+
+	// Optional task-name substring; matching tasks' output is returned in task_outputs
+	TaskOutputFilter string `json:"task_output_filter,omitempty"`
+
+	// Optional ansible strategy plugin (linear|free|host_pinned); empty keeps ansible's default
+	Strategy string `json:"strategy,omitempty"`
+
+	// Optional batch size (2 or "25%"), passed as the "serial" extra var, which the
+	// shipped playbooks' plays use as `serial: "{{ serial | default(0) }}"`
+	Serial Serial `json:"serial,omitempty"`
+
+	// Optional playbook tuning knobs (e.g. shared_buffers), passed as --extra-vars
+	// JSON; connection and db credentials stay in the inventory
+	ExtraVars map[string]string `json:"extra_vars,omitempty"`
+
+	// Optional dry run: ansible-playbook --check previews changes without making them
+	CheckMode bool `json:"check_mode,omitempty"`
+
+	// Optional playbook tags to run (--tags) or skip (--skip-tags)
+	Tags     []string `json:"tags,omitempty"`
+	SkipTags []string `json:"skip_tags,omitempty"`
+
+	// Optional 0..maxVerbosity, the number of -v flags for ansible-playbook
+	Verbosity int `json:"verbosity,omitempty"`
+
+	// Optional "install" (default), "uninstall", which runs the db_type's teardown
+	// playbook instead and doesn't need db credentials, or "reconfigure", which only
+	// reapplies settings, users and grants on an installed database
+	Action string `json:"action,omitempty"`
+
+	// Optional post-install check: after a successful install, each db_type's
+	// "_verify" playbook connects to the new database and runs SELECT 1
+	Verify bool `json:"verify,omitempty"`
+
+	// Optional: publish the playbook output live, masked, on db.install.logs.<id>
+	// while it runs (see outputstream.go); the final status is unchanged
+	StreamOutput bool `json:"stream_output,omitempty"`
+
+	// Optional playbook to run instead of the db_type's, relative to PLAYBOOK_DIR
+	// (see requestPlaybook); os_family and action don't change it
+	PlaybookPath string `json:"playbook_path,omitempty"`
+
+	// Optional caller-chosen key: another request with the same key within
+	// IDEMPOTENCY_TTL gets this run's final status again instead of a second run
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
+// modeCheck marks the statuses of a check_mode request.
+const modeCheck = "check"
+
+// maxVerbosity is -vvvv, connection debugging.
+const maxVerbosity = 4
+
+// Request actions; an empty action means actionInstall.
+const (
+	actionInstall     = "install"
+	actionUninstall   = "uninstall"
+	actionReconfigure = "reconfigure"
+)
+
+// reconfigureTag marks the tasks of an install playbook that a reconfigure runs
+// when the db_type has no _reconfigure playbook of its own.
+const reconfigureTag = "configure"
+
+// validStrategies are the ansible strategy plugins a request may select.
+var validStrategies = []string{"linear", "free", "host_pinned"}
+
 type InstallStatus struct {
-	ID              int       `json:"id"`
-	Name            string    `json:"name"`
-	Status          string    `json:"status"` // "success" | "error"
-	Inventory       string    `json:"inventory"`
-	AnsibleExitCode int       `json:"ansible_exit_code"`
-	AnsibleOutput   string    `json:"ansible_output,omitempty"`
-	Timestamp       time.Time `json:"timestamp"`
-	Error           string    `json:"error,omitempty"`
+	ID                  int                       `json:"id"`
+	Name                string                    `json:"name"`
+	RunID               string                    `json:"run_id"`              // unique per handled message
+	TraceID             string                    `json:"trace_id,omitempty"`  // with tracing on or a traceparent header
+	ReplayOf            string                    `json:"replay_of,omitempty"` // idempotency_key duplicates: run_id of the original run
+	WorkerID            string                    `json:"worker_id,omitempty"` // WORKER_ID of the worker that published it
+	Stage               string                    `json:"stage"`               // lifecycle stage, see stage* consts
+	Status              string                    `json:"status"`              // "success" | "error" | "running"
+	DBType              string                    `json:"db_type,omitempty"`   // running statuses: the db_type being installed
+	Inventory           string                    `json:"inventory"`
+	Playbook            string                    `json:"playbook,omitempty"`       // once selected; multi-type requests: the failing (else first) db_type's
+	InventoryVars       []string                  `json:"inventory_vars,omitempty"` // names only; on error or with REPORT_INVENTORY_VARS
+	Priority            int                       `json:"priority"`
+	Strategy            string                    `json:"strategy,omitempty"`
+	Mode                string                    `json:"mode,omitempty"`   // "check" for dry runs; empty for real installs
+	Action              string                    `json:"action,omitempty"` // the request's action; empty if it named none
+	Serial              Serial                    `json:"serial,omitempty"`
+	EstimatedDurationMs int64                     `json:"estimated_duration_ms,omitempty"` // running statuses only
+	StartedAt           *time.Time                `json:"started_at,omitempty"`            // final statuses: when the (first) playbook started
+	DurationMs          int64                     `json:"duration_ms,omitempty"`           // final statuses: playbook run time, until the last one returned
+	Attempts            int                       `json:"attempts,omitempty"`              // final statuses: playbook runs, incl. retries (summed over db_types)
+	ElapsedMs           int64                     `json:"elapsed_ms,omitempty"`            // heartbeats: time since the playbook started
+	Seq                 int                       `json:"seq,omitempty"`                   // heartbeats: 1, 2, ... per db_type
+	AnsibleExitCode     int                       `json:"ansible_exit_code"`
+	ExitReason          string                    `json:"exit_reason,omitempty"` // AnsibleExitCode by name, see exitReason
+	CommandLine         string                    `json:"command_line,omitempty"`
+	AnsibleOutput       string                    `json:"ansible_output,omitempty"`
+	OutputChunks        int                       `json:"output_chunks,omitempty"` // AnsibleOutput moved to db.install.log.chunk
+	Recap               string                    `json:"recap,omitempty"`         // raw PLAY RECAP block
+	RecapStats          map[string]int            `json:"recap_stats,omitempty"`   // PLAY RECAP counters summed over hosts (ok, changed, failed, ...)
+	HostResults         map[string]map[string]int `json:"host_results,omitempty"`  // multi-host requests: PLAY RECAP counters per host, summed over db_types
+	Fingerprint         string                    `json:"result_fingerprint,omitempty"`
+	TaskOutputs         []TaskOutput              `json:"task_outputs,omitempty"`
+	FailedTasks         []FailedTask              `json:"failed_tasks,omitempty"`      // final statuses: task, host and msg of each failed task
+	ResultData          map[string]any            `json:"result_data,omitempty"`       // from the playbook's result_file
+	Results             []TypeResult              `json:"results,omitempty"`           // per db_type, for multi-type requests
+	Facts               map[string]any            `json:"facts,omitempty"`             // db.install.facts: ansible_facts by host
+	OutputLog           string                    `json:"output_log,omitempty"`        // OUTPUT_LOG_DIR: file with the full ansible_output
+	Verified            *bool                     `json:"verified,omitempty"`          // verify requests: whether the post-install check passed (absent if it never ran)
+	ConnectionString    string                    `json:"connection_string,omitempty"` // successful installs; password masked on db.install.status
+	Timestamp           time.Time                 `json:"timestamp"`
+	Batch               []BatchItem               `json:"batch,omitempty"` // per-host outcome of a batch request
+	BatchCounts         *BatchCounts              `json:"batch_counts,omitempty"`
+	Error               string                    `json:"error,omitempty"`
+	ErrorCode           string                    `json:"error_code,omitempty"`
+	Category            string                    `json:"category,omitempty"`       // error statuses: see cat* consts
+	ErrorKind           string                    `json:"error_kind,omitempty"`     // error statuses: Category as an errorKind
+	SSHDiagnostic       *SSHDiagnostic            `json:"ssh_diagnostic,omitempty"` // failed runs whose output shows an SSH problem
 }
 
+// Stage values for InstallStatus
+const (
+	stageRunning   = "running"   // a playbook has started (one per db_type)
+	stageHeartbeat = "heartbeat" // a playbook is still running, every heartbeatInterval
+	stageDeferred  = "deferred"  // waiting on a transient condition (e.g. disk full) before retrying
+	stageCanary    = "canary"    // canary_first: the first host's batch item, before the others run
+	stageFinal     = "final"     // the run's outcome; published once per run
+)
+
+// ErrorCode values for InstallStatus
+const (
+	errCodeNoHosts         = "NO_HOSTS"         // ansible exited 0 but no host matched (strict mode only)
+	errCodeUnreachable     = "UNREACHABLE"      // preflight or ansible ping could not reach the host
+	errCodeNoSpace         = "NO_SPACE"         // inventory dir still full after sweeping and retrying
+	errCodeShutdown        = "SHUTDOWN"         // the worker stopped before the run finished; safe to resubmit
+	errCodeVerifyFailed    = "VERIFY_FAILED"    // the install succeeded but the new database failed its verification
+	errCodePlaybookInvalid = "PLAYBOOK_INVALID" // the playbook failed --syntax-check (SYNTAX_CHECK); it never ran
+	errCodeDuplicate       = "DUPLICATE"        // a run with the same idempotency_key is still in progress
+)
+
 func main() {
-	natsURL := envOr("NATS_URL", defaultNatsURL)
+	setupLogging(envLogFormat(), envLogLevel())
+	cfg = loadConfig()
+	natsURL := cfg.NatsURL
+
+	_, err := reloadPlaybooks()
+	mustNoErr(err, "load playbook allowlist")
+
+	version, err := checkAnsibleVersion(cfg.AnsiblePlaybookBin, cfg.MinAnsibleVersion)
+	mustNoErr(err, "check ansible version")
+	slog.Info("found ansible", "bin", cfg.AnsiblePlaybookBin, "version", version)
+
+	// Optional trace export; sent last, after in-flight runs have finished
+	if cfg.OTLPEndpoint != "" {
+		defer startTracing()()
+	}
+
+	// Graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ready atomic.Bool
+	draining := watchSignals(cancel, &ready)
+
+	// Optional guard against a second worker on this node sharing the inventory dir
+	if cfg.InstanceLock {
+		lock, err := acquireInstanceLock(ctx, cfg.LockFile, cfg.LockMode)
+		mustNoErr(err, "acquire instance lock")
+		defer lock.Close()
+	}
+
+	// Inventories (and key files) left behind by a crash still hold credentials.
+	// Holding the instance lock, we own the inventory dir and can remove them all; otherwise
+	// another worker might be using it, so only stale ones go.
+	orphanAge := cfg.StaleInventoryAge
+	if cfg.InstanceLock {
+		orphanAge = 0
+	}
+	if n := sweepInventories(cfg.InventoryDir, orphanAge); n > 0 {
+		slog.Info("removed leftover inventory files", "count", n)
+	}
+	// and so may result files, e.g. generated credentials
+	if cfg.ResultDir != "" {
+		if n := sweepInventories(cfg.ResultDir, orphanAge); n > 0 {
+			slog.Info("removed leftover result files", "count", n)
+		}
+	}
+
+	// Optional: make sure the collections the playbooks use are there before taking requests
+	if cfg.GalaxyRequirements != "" {
+		mustNoErr(installGalaxyRequirements(ctx, cfg.GalaxyRequirements), "install ansible-galaxy requirements")
+	}
 
-	// Connect to NATS
-	nc, err := nats.Connect(natsURL,
+	// Connect to NATS, optionally after a random delay
+	connClosed := make(chan struct{})
+	authOpts, err := natsAuthOptions(cfg)
+	mustNoErr(err, "load NATS credentials")
+	opts := append([]nats.Option{
 		nats.Name("db-install-worker"),
 		nats.MaxReconnects(-1),
-	)
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			slog.Info("reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+			flushPendingStatuses(nc)
+		}),
+		nats.DrainTimeout(cfg.DrainTimeout),
+		nats.ClosedHandler(func(*nats.Conn) { close(connClosed) }),
+	}, authOpts...)
+	if jitterMax := cfg.StartupJitterMax; jitterMax > 0 {
+		delay := mrand.N(jitterMax)
+		slog.Info("waiting before connecting", "delay", delay.Round(time.Millisecond), "jitter_max", jitterMax)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		opts = append(opts, nats.CustomReconnectDelay(func(int) time.Duration {
+			return nats.DefaultReconnectWait + mrand.N(jitterMax)
+		}))
+	}
+	nc, err := nats.Connect(natsURL, opts...)
 	mustNoErr(err, "connect NATS")
-	defer nc.Drain()
+	defer drainConn(nc, connClosed, cfg.DrainTimeout)
 
-	log.Printf("[startup] connected to NATS at %s", natsURL)
+	slog.Info("connected to NATS", "url", natsURL)
 
-	// Graceful shutdown
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	// Optional probe endpoint; reports unhealthy until ready
+	if cfg.HealthAddr != "" {
+		startHealthServer(ctx, nc, cfg.HealthAddr, &ready, draining)
+	}
+	if cfg.MetricsAddr != "" {
+		startMetricsServer(ctx, cfg.MetricsAddr)
+	}
 
-	// Queue group so multiple workers share the load (optional)
-	sub, err := nc.QueueSubscribe(subjectInstall, "db-install-workers", func(msg *nats.Msg) {
-		handleMessage(ctx, nc, msg)
-	})
+	runSlots = newAdmission(cfg.MaxConcurrentRuns)
+	runner := execRunner{}
+
+	// Queue group so multiple workers share the load (optional).
+	// Handlers run concurrently; runSlots bounds how many playbooks run at once.
+	onInstall := func(msg *nats.Msg) {
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			handleMessage(ctx, nc, runner, msg)
+		}()
+	}
+	var sub *nats.Subscription
+	if cfg.JetStream {
+		sub, err = subscribeInstallJetStream(nc, onInstall)
+		jetStreamSub.Store(sub)
+	} else {
+		sub, err = nc.QueueSubscribe(cfg.SubjectInstall, cfg.QueueGroup, onInstall)
+	}
 	mustNoErr(err, "subscribe to subject")
 	defer sub.Unsubscribe()
 
-	log.Printf("[ready] listening on subject %q; will publish status to %q", subjectInstall, subjectInstallStatus)
+	factsSub, err := nc.QueueSubscribe(subjectFacts, cfg.QueueGroup, func(msg *nats.Msg) {
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			handleFacts(ctx, nc, msg)
+		}()
+	})
+	mustNoErr(err, "subscribe to facts subject")
+	defer factsSub.Unsubscribe()
+
+	// Every worker gets cancels; only the one running the install acts on it
+	cancelSub, err := nc.Subscribe(subjectCancel, handleCancel)
+	mustNoErr(err, "subscribe to cancel subject")
+	defer cancelSub.Unsubscribe()
+
+	configSub, err := nc.Subscribe(subjectConfig, handleConfig)
+	mustNoErr(err, "subscribe to config subject")
+	defer configSub.Unsubscribe()
+
+	statsSub, err := nc.Subscribe(subjectStats, handleStats)
+	mustNoErr(err, "subscribe to stats subject")
+	defer statsSub.Unsubscribe()
+
+	// Every worker reloads, so this is a plain subscription rather than the queue group
+	reloadSub, err := nc.Subscribe(subjectReloadPlaybooks, handleReloadPlaybooks)
+	mustNoErr(err, "subscribe to playbook reload subject")
+	defer reloadSub.Unsubscribe()
+
+	// Optional relay of statuses to browsers that can't speak NATS
+	if cfg.WSAddr != "" {
+		mustNoErr(startStatusBridge(ctx, nc, cfg.WSAddr), "start websocket bridge")
+	}
+
+	slog.Info("ready", "worker_id", cfg.WorkerID, "subject", cfg.SubjectInstall, "status_subject", cfg.SubjectStatus, "queue_group", cfg.QueueGroup)
+	ready.Store(true)
+
+	// SIGHUP reloads the playbook allowlist, same as the control subject
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	<-ctx.Done()
-	log.Println("[shutdown] stopping worker...")
+	for {
+		select {
+		case <-hup:
+			if _, err := reloadPlaybooks(); err != nil {
+				slog.Error("reload playbooks failed", "err", err)
+			}
+		case <-draining:
+			// stop taking requests (other workers in the queue group pick them up)
+			sub.Unsubscribe()
+			factsSub.Unsubscribe()
+			if waitInflight(ctx, cfg.ShutdownGrace) {
+				slog.Info("in-flight runs finished, stopping worker")
+			} else {
+				slog.Info("grace period over, cancelling remaining runs")
+			}
+			cancel()
+			flushInflight()
+			return
+		case <-ctx.Done():
+			slog.Info("stopping worker")
+			flushInflight()
+			return
+		}
+	}
 }
 
 // ------------ message handling ------------
 
-func handleMessage(parent context.Context, nc *nats.Conn, msg *nats.Msg) {
-	time.Sleep(10 * time.Second)
-	var req InstallRequest
-	if err := json.Unmarshal(msg.Data, &req); err != nil {
-		log.Printf("[warn] invalid JSON: %v", err)
-		publishStatus(nc, InstallStatus{
+func handleMessage(parent context.Context, nc *nats.Conn, runner PlaybookRunner, msg *nats.Msg) {
+	started := time.Now()
+
+	// Every outcome goes through publish, so the last status is the run's result
+	runID := newRunID()
+	d := newDelivery(msg)
+	parent, span := startSpan(remoteParent(parent, msg.Header), "handleMessage")
+	span.SetAttr("run_id", runID)
+	var (
+		mu    sync.Mutex // parallel db_type runs publish concurrently
+		final InstallStatus
+		req   InstallRequest
+	)
+	publish := func(st InstallStatus) {
+		st.RunID = runID
+		st.TraceID = span.TraceID()
+		if req.CheckMode {
+			st.Mode = modeCheck
+		}
+		st.Action = req.Action
+		if st.Stage == "" {
+			st.Stage = stageFinal
+		}
+		if st.Stage == stageFinal {
+			mu.Lock()
+			final = st
+			mu.Unlock()
+			if st.ReplayOf == "" {
+				// a replay's install was counted when it ran
+				observeInstall(st)
+			}
+		}
+		reply := ""
+		if st.Stage == stageFinal && !d.js {
+			// under JetStream msg.Reply is the ack subject, not the caller's inbox
+			reply = msg.Reply
+		}
+		publishStatus(nc, st, reply)
+	}
+	if cfg.SummaryLine {
+		defer func() { printSummary(final, time.Since(started)) }()
+	}
+
+	// Under JetStream, settle the message once the final status is out. retry marks
+	// failures worth another attempt (possibly on another worker).
+	var retry bool
+	defer func() { span.endInstall(final) }()
+	defer func() { d.settle(parent, final, retry) }()
+	defer d.keepAlive()()
+
+	time.Sleep(startDelay)
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		slog.Warn("invalid request JSON", "err", err)
+		deadLetter(nc, msg, err)
+		publish(InstallStatus{
 			ID:        0,
 			Name:      "",
 			Status:    "error",
 			Error:     fmt.Sprintf("invalid JSON: %v", err),
+			Category:  catClient,
 			Timestamp: time.Now(),
 		})
 		return
 	}
 
+	span.SetAttr("install.id", req.ID)
+	span.SetAttr("install.name", req.Name)
+
 	// Basic validation
-	if err := validateRequest(req); err != nil {
-		log.Printf("[warn] invalid request (id=%d name=%q): %v", req.ID, req.Name, err)
-		publishStatus(nc, InstallStatus{
+	_, validateSpan := startSpan(parent, "validate")
+	err := validateRequest(req)
+	if err != nil {
+		validateSpan.SetError(err.Error())
+	}
+	validateSpan.End()
+	if err != nil {
+		slog.Warn("invalid request", "id", req.ID, "name", req.Name, "err", err)
+		publish(InstallStatus{
 			ID:        req.ID,
 			Name:      req.Name,
 			Status:    "error",
 			Error:     err.Error(),
+			Category:  catClient,
 			Timestamp: time.Now(),
 		})
 		return
 	}
 
-	// Wait until SSH on the target IP is reachable (blocks until success or service is stopped)
-	if err := waitForSSH(parent, req.IPAddress); err != nil {
-		log.Printf("[error] SSH not reachable for id=%d (%s): %v", req.ID, req.IPAddress, err)
-		publishStatus(nc, InstallStatus{
+	// A key handled recently means this install already ran (or is running)
+	if key := req.IdempotencyKey; key != "" {
+		prior, ok := claimIdempotencyKey(key, runID)
+		if !ok {
+			slog.Info("duplicate idempotency_key, not running", "id", req.ID, "key", key, "run_id", prior.runID, "done", prior.done)
+			st := prior.final
+			if !prior.done {
+				st = InstallStatus{
+					ID:        req.ID,
+					Name:      req.Name,
+					Status:    "error",
+					Error:     "a run with this idempotency_key is in progress",
+					ErrorCode: errCodeDuplicate,
+					Category:  catClient,
+				}
+			}
+			st.ReplayOf = prior.runID
+			st.Timestamp = time.Now()
+			publish(st)
+			return
+		}
+		// runs that were cut short or are to be retried don't count
+		defer func() {
+			keep := !retry && parent.Err() == nil && final.Category != catCancelled
+			releaseIdempotencyKey(key, runID, final, keep)
+		}()
+	}
+
+	// From here on, db.install.cancel can stop the run
+	ctx, untrack := trackRun(parent, req.ID, runID)
+	defer untrack()
+
+	// One run per VM at a time; the next one waits here, cancellable
+	unlockHosts, err := lockHosts(ctx, req.hostAddresses(), cfg.HostLockWait)
+	if err != nil {
+		category := networkCategory(ctx)
+		if errors.Is(err, errHostBusy) {
+			category = catHostBusy
+		}
+		slog.Warn("host busy", "id", req.ID, "err", err)
+		publish(InstallStatus{
 			ID:        req.ID,
 			Name:      req.Name,
 			Status:    "error",
-			Error:     "SSH not reachable: " + err.Error(),
+			Error:     err.Error(),
+			Category:  category,
 			Timestamp: time.Now(),
 		})
+		retry = category != catCancelled
+		return
+	}
+	defer unlockHosts()
+
+	// Behind a bastion the VMs can't be dialed directly; the bastion is checked instead
+	hops := req.firstHops()
+
+	// Optional quick preflight so plainly-down hosts fail fast instead of waiting below
+	for _, hop := range hops {
+		if !cfg.PreflightValidate {
+			break
+		}
+		if err := preflight(ctx, hop.addr, hop.port, cfg.PreflightTimeout); err != nil {
+			slog.Warn("preflight failed", "id", req.ID, "addr", hop.addr, "err", err)
+			publish(InstallStatus{
+				ID:        req.ID,
+				Name:      req.Name,
+				Status:    "error",
+				Error:     "preflight failed: " + err.Error(),
+				ErrorCode: errCodeUnreachable,
+				Category:  networkCategory(ctx),
+				Timestamp: time.Now(),
+			})
+			retry = networkCategory(ctx) == catNetwork
+			return
+		}
+	}
+
+	// Wait until SSH on every target is reachable (blocks until success or service is stopped)
+	for _, hop := range hops {
+		if err := waitForSSH(ctx, hop.addr, hop.port); err != nil {
+			slog.Error("SSH not reachable", "id", req.ID, "addr", hop.addr, "err", err)
+			publish(InstallStatus{
+				ID:        req.ID,
+				Name:      req.Name,
+				Status:    "error",
+				Error:     "SSH not reachable: " + err.Error(),
+				Category:  networkCategory(ctx),
+				Timestamp: time.Now(),
+			})
+			retry = networkCategory(ctx) == catNetwork
+			return
+		}
+	}
+
+	// Several hosts, one at a time; each host gets its own inventory when its turn comes
+	if req.Batch || req.CanaryFirst || req.ParallelHosts {
+		ir := installRun{req: req, runID: runID, priority: effectivePriority(req.Priority), publish: publish, runner: runner, nc: nc}
+		publish(ir.runBatch(ctx))
 		return
 	}
 
 	// 1) Write an inventory file
-	invPath, err := writeInventory(req)
+	_, invSpan := startSpan(ctx, "writeInventory")
+	invPath, err := writeInventory(req, runID)
+	if errors.Is(err, syscall.ENOSPC) {
+		if d.js {
+			// JetStream redelivers it later, maybe to a worker with room; don't hold it here
+			invSpan.SetError(err.Error())
+			invSpan.End()
+			deferForFullDisk(req, publish)
+			retry = true
+			return
+		}
+		invPath, err = retryInventoryOnFullDisk(ctx, req, runID, publish)
+	}
+	if err != nil {
+		invSpan.SetError(err.Error())
+	}
+	invSpan.End()
 	if err != nil {
-		log.Printf("[error] write inventory failed (id=%d): %v", req.ID, err)
-		publishStatus(nc, InstallStatus{
+		slog.Error("write inventory failed", "id", req.ID, "err", err)
+		publish(InstallStatus{
 			ID:        req.ID,
 			Name:      req.Name,
 			Status:    "error",
 			Error:     err.Error(),
+			Category:  catInternal,
 			Timestamp: time.Now(),
 		})
+		retry = true // e.g. still NO_SPACE: try again later
 		return
 	}
 
 	// ensure secrets don't linger on disk
-	defer func(p string) {
-		if p == "" {
+	defer removeInventory(invPath)
+
+	// Optional ansible ping, so an unreachable host or a rejected login fails now
+	// rather than partway into the playbook
+	if cfg.PreflightPing {
+		if st := pingFailure(req, invPath, pingHost(ctx, req, invPath)); st.Status != "" {
+			slog.Warn("ansible ping failed", "id", req.ID, "hosts", len(req.targets()), "exit_code", st.AnsibleExitCode, "category", st.Category)
+			publish(st)
+			retry = st.Category == catNetwork
 			return
 		}
-		if rmErr := os.Remove(p); rmErr != nil {
-			log.Printf("[warn] failed to remove inventory %s: %v", p, rmErr)
-		} else {
-			log.Printf("[ok] removed inventory %s", p)
-		}
-	}(invPath)
-
-	// 2) Choose a playbook based on db_type
-	playbookPath, err := selectPlaybook(req.DBType)
-	if err != nil {
-		publishStatus(nc, InstallStatus{
-			ID: req.ID, Name: req.Name, Status: "error",
-			Inventory: invPath, Error: err.Error(), Timestamp: time.Now(),
-		})
-		return
 	}
 
-	// 3) Run ansible playbook
-	exitCode, output, runErr := runPlaybook(parent, invPath, playbookPath)
-
-	// Prepare status
-	status := "success"
-	errMsg := ""
-	if runErr != nil || exitCode != 0 {
-		status = "error"
-		if runErr != nil {
-			errMsg = runErr.Error()
+	// 2) Run the playbook of each requested db_type (usually just one)
+	priority := effectivePriority(req.Priority)
+	types := req.dbTypes()
+	results := make([]playResult, len(types))
+	ir := &installRun{req: req, runID: runID, invPath: invPath, priority: priority, publish: publish, runner: runner, nc: nc}
+	if req.Parallel && len(types) > 1 {
+		// validation guarantees distinct engines, so the runs don't step on each other
+		var wg sync.WaitGroup
+		for i, t := range types {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = ir.runDBType(ctx, t)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i, t := range types {
+			results[i] = ir.runDBType(ctx, t)
 		}
 	}
 
-	publishStatus(nc, InstallStatus{
-		ID:              req.ID,
-		Name:            req.Name,
-		Status:          status,
-		Inventory:       invPath,
-		AnsibleExitCode: exitCode,
-		AnsibleOutput:   truncate(string(output), maxOutputBytes),
-		Error:           errMsg,
-		Timestamp:       time.Now(),
-	})
+	st := InstallStatus{
+		ID:        req.ID,
+		Name:      req.Name,
+		Inventory: invPath,
+		Priority:  priority,
+		Strategy:  req.Strategy,
+		Serial:    req.Serial,
+	}
+	applyResults(&st, req, results)
+	if cfg.OutputLogDir != "" {
+		if full := combinedOutput(results); full != "" {
+			var path string
+			err := withUmask(func() (err error) {
+				path, err = writeOutputLog(cfg.OutputLogDir, req.ID, runID, started, full)
+				return err
+			})
+			if err != nil {
+				slog.Warn("keep full output failed", "id", req.ID, "err", err)
+			}
+			st.OutputLog = path
+		}
+	}
+	if req.Verify && st.Status == "success" {
+		ir.verify(ctx, &st, types)
+	}
+	if st.Status == "success" && !req.CheckMode && req.Action != actionUninstall {
+		st.ConnectionString = connectionString(req, types)
+	}
+	if st.Status == "error" || cfg.ReportInventoryVars {
+		_, st.InventoryVars = renderInventory(req, invPath)
+	}
+	st.Timestamp = time.Now()
+	publish(st)
 }
 
 // ------------ helpers ------------
 
 func validateRequest(r InstallRequest) error {
+	if err := validateTarget(r); err != nil {
+		return err
+	}
+	switch {
+	case (r.Batch || r.CanaryFirst || r.ParallelHosts) && len(r.Hosts) == 0:
+		return errors.New("batch needs hosts")
+	case r.CanaryFirst && len(r.Hosts) < 2:
+		return errors.New("canary_first needs at least two hosts")
+	}
+	if r.Action != "" && r.Action != actionInstall && r.Action != actionUninstall && r.Action != actionReconfigure {
+		return fmt.Errorf("invalid action %q (allowed: %s, %s, %s)", r.Action, actionInstall, actionUninstall, actionReconfigure)
+	}
+	// a teardown removes the whole server, so db creds are optional there
+	if r.Action != actionUninstall && (r.DBName == "" || r.DBUser == "" || r.DBPassword == "") {
+		return errors.New("missing db creds or db_name")
+	}
+	// both reach SQL through the playbooks
+	if r.DBName != "" && !dbIdentRe.MatchString(r.DBName) {
+		return fmt.Errorf("invalid db_name %q (must match %s)", r.DBName, dbIdentRe)
+	}
+	if r.DBUser != "" && !dbIdentRe.MatchString(r.DBUser) {
+		return fmt.Errorf("invalid db_user %q (must match %s)", r.DBUser, dbIdentRe)
+	}
+	if r.DBType != "" && len(r.DBTypes) > 0 {
+		return errors.New("set either db_type or db_types, not both")
+	}
+	// db_type may carry a version suffix, e.g. "postgresql15"
+	seen := map[string]bool{}
+	for _, t := range r.dbTypes() {
+		canonical, suffix, err := normalizeDBType(t)
+		if err != nil {
+			return err
+		}
+		if r.DBVersion != "" {
+			if len(r.dbTypes()) > 1 {
+				return errors.New("db_version needs a single db_type; use version suffixes in db_types")
+			}
+			if suffix != "" && suffix != r.DBVersion {
+				return fmt.Errorf("db_version %q conflicts with db_type %q", r.DBVersion, t)
+			}
+			if err := validateDBVersion(canonical, r.DBVersion); err != nil {
+				return err
+			}
+		}
+		if seen[canonical] {
+			return fmt.Errorf("duplicate db_type %q in db_types", canonical)
+		}
+		seen[canonical] = true
+		if r.Verify {
+			if _, err := verifyPlaybook(canonical); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Verify && (r.CheckMode || r.Action == actionUninstall) {
+		return errors.New("verify needs a real install, not check_mode or uninstall")
+	}
+	if r.PlaybookPath != "" {
+		if len(r.dbTypes()) > 1 {
+			return errors.New("playbook_path needs a single db_type")
+		}
+		if _, err := requestPlaybook(r.PlaybookPath, r.Action); err != nil {
+			return err
+		}
+	}
+	if len(r.IdempotencyKey) > maxIdempotencyKey {
+		return fmt.Errorf("idempotency_key too long (max %d bytes)", maxIdempotencyKey)
+	}
+	if r.TimeoutSeconds < 0 {
+		return fmt.Errorf("invalid timeout_seconds %d (must be positive)", r.TimeoutSeconds)
+	}
+	if r.DBPort != 0 && (r.DBPort < 1 || r.DBPort > 65535) {
+		return fmt.Errorf("invalid db_port %d (must be 1-65535)", r.DBPort)
+	}
+	// the engines would all be configured to listen on it
+	if r.DBPort != 0 && len(r.dbTypes()) > 1 {
+		return errors.New("db_port needs a single db_type")
+	}
+	if err := validateExtraArgs(r.ExtraArgs); err != nil {
+		return err
+	}
+	if err := validateExtraVars(r.ExtraVars); err != nil {
+		return err
+	}
+	if r.Verbosity < 0 || r.Verbosity > maxVerbosity {
+		return fmt.Errorf("invalid verbosity %d (must be 0-%d)", r.Verbosity, maxVerbosity)
+	}
+	if err := validateTags("tags", r.Tags); err != nil {
+		return err
+	}
+	if err := validateTags("skip_tags", r.SkipTags); err != nil {
+		return err
+	}
+	if r.OSFamily != "" && !slices.Contains(validOSFamilies, r.OSFamily) {
+		return fmt.Errorf("invalid os_family %q (allowed: %s)", r.OSFamily, strings.Join(validOSFamilies, ", "))
+	}
+	if r.Strategy != "" && !slices.Contains(validStrategies, r.Strategy) {
+		return fmt.Errorf("invalid strategy %q (allowed: %s)", r.Strategy, strings.Join(validStrategies, ", "))
+	}
+	if r.Serial != "" {
+		if err := r.Serial.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTarget checks what's needed to reach the VM(s) at all (no db fields).
+func validateTarget(r InstallRequest) error {
 	if r.ID == 0 {
 		return errors.New("missing id")
 	}
 	if strings.TrimSpace(r.Name) == "" {
 		return errors.New("missing name")
 	}
-	if _, err := netip.ParseAddr(r.IPAddress); err != nil {
-		return fmt.Errorf("invalid ip_address: %v", err)
+	if err := validateTargets(r); err != nil {
+		return err
 	}
-	if r.VMUser == "" || r.VMPassword == "" {
-		return errors.New("missing vm_user or vm_password")
+	if err := validateBastion(r); err != nil {
+		return err
 	}
-	if r.DBName == "" || r.DBUser == "" || r.DBPassword == "" {
-		return errors.New("missing db creds or db_name")
+	if err := validateSSHCommonArgs(r.SSHCommonArgs); err != nil {
+		return err
+	}
+	if r.VMUser == "" {
+		return errors.New("missing vm_user")
+	}
+	if r.VMPassword == "" && r.SSHPrivateKey == "" {
+		return errors.New("missing vm_password or ssh_private_key")
 	}
-	// Optional: enforce db_type == "postgresql"
-	if !strings.EqualFold(r.DBType, "postgresql") {
-		return fmt.Errorf("unsupported db_type %q (only 'postgresql' supported)", r.DBType)
+	if r.SSHPrivateKey != "" && !strings.Contains(r.SSHPrivateKey, "PRIVATE KEY-----") {
+		return errors.New("ssh_private_key must be a PEM/OpenSSH private key")
+	}
+	if r.BecomeMethod != "" && !slices.Contains(becomeMethods, r.BecomeMethod) {
+		return fmt.Errorf("invalid become_method %q (allowed: %s)", r.BecomeMethod, strings.Join(becomeMethods, ", "))
+	}
+	// these end up as inventory values, where a line break would start a new entry;
+	// vault-encrypted passwords are rendered as block scalars and checked by validateVault
+	vaulted := r.vaultFields()
+	for _, f := range []struct{ name, value string }{
+		{"vm_user", r.VMUser}, {"vm_password", r.VMPassword}, {"become_password", r.BecomePassword},
+		{"db_name", r.DBName}, {"db_user", r.DBUser}, {"db_password", r.DBPassword},
+	} {
+		if _, ok := vaulted[f.name]; !ok && strings.ContainsAny(f.value, "\r\n\x00") {
+			return fmt.Errorf("%s must not contain line breaks or NUL", f.name)
+		}
+	}
+	if strings.ContainsRune(r.KnownHosts, 0) {
+		return errors.New("known_hosts must not contain NUL")
+	}
+	return validateVault(r)
+}
+
+// becomeMethods are the become_method values a request may use; the first is the default.
+var becomeMethods = []string{"sudo", "su", "doas"}
+
+// hostnameRe is a DNS name made of RFC 1123 labels, e.g. "db01.internal".
+var hostnameRe = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// bastionUserRe is a login name safe to put in the ProxyCommand shell line.
+var bastionUserRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,31}$`)
+
+func validateBastion(r InstallRequest) error {
+	if r.BastionHost == "" {
+		if r.BastionUser != "" || r.BastionPort != 0 {
+			return errors.New("bastion_user and bastion_port need a bastion_host")
+		}
+		return nil
+	}
+	if err := validateHost(r.BastionHost); err != nil {
+		return fmt.Errorf("invalid bastion_host: %v", err)
+	}
+	if r.BastionUser != "" && !bastionUserRe.MatchString(r.BastionUser) {
+		return fmt.Errorf("invalid bastion_user %q (must match %s)", r.BastionUser, bastionUserRe)
+	}
+	if r.BastionPort != 0 && (r.BastionPort < 1 || r.BastionPort > 65535) {
+		return fmt.Errorf("invalid bastion_port %d (must be 1-65535)", r.BastionPort)
 	}
 	return nil
 }
 
-func writeInventory(r InstallRequest) (string, error) {
-	if err := os.MkdirAll(inventoryDir, 0o755); err != nil {
+// validateHost accepts an IPv4/IPv6 address or a DNS hostname as a target.
+func validateHost(s string) error {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		if addr.Zone() != "" {
+			return fmt.Errorf("%q: IPv6 zones are not supported", s)
+		}
+		return nil
+	}
+	if len(s) > 253 || !hostnameRe.MatchString(s) {
+		return fmt.Errorf("%q is neither an IP address nor a hostname", s)
+	}
+	// an all-numeric last label means a mistyped IPv4 address, not a name
+	labels := strings.Split(s, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return fmt.Errorf("%q is not a valid IP address", s)
+	}
+	return nil
+}
+
+// inventoryHost is how the target appears as the inventory host: IPs in canonical
+// form (IPv4-mapped IPv6 unmapped), hostnames as given. IPv6 stays unbracketed;
+// ansible only wants brackets around one with a port attached, and the port always
+// goes in ansible_port instead.
+func inventoryHost(s string) string {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap().String()
+	}
+	return s
+}
+
+// validateExtraArgs only lets through flags on the configured allowlist, so callers
+// can't smuggle in things like -e with secrets or --vault-password-file.
+// An entry "--flag" also permits "--flag=value".
+func validateExtraArgs(args []string) error {
+	for _, a := range args {
+		allowed := false
+		for _, flag := range cfg.AllowedExtraArgs {
+			if a == flag || strings.HasPrefix(a, flag+"=") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("extra arg %q is not allowed", a)
+		}
+	}
+	return nil
+}
+
+// dbIdentRe is a database or user name that is a plain identifier in every supported
+// engine: a letter or '_', then up to 62 more letters, digits or '_' (PostgreSQL's 63-byte limit).
+var dbIdentRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+// extraVarNameRe is a valid ansible variable name.
+var extraVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedExtraVars are set by the worker itself. Extra vars take precedence over
+// everything, so letting a request set these (or any ansible_* var) would override
+// the inventory's connection and credentials.
+var reservedExtraVars = []string{"db_name", "db_user", "db_password", "db_port", "db_version", "serial", "result_file"}
+
+// validateExtraVars checks the names of a request's extra vars, and rejects Jinja
+// delimiters in values since ansible templates extra vars when they are used.
+func validateExtraVars(vars map[string]string) error {
+	for k, v := range vars {
+		if !extraVarNameRe.MatchString(k) {
+			return fmt.Errorf("invalid extra_vars name %q", k)
+		}
+		if strings.HasPrefix(k, "ansible_") || slices.Contains(reservedExtraVars, k) {
+			return fmt.Errorf("extra_vars may not set %q", k)
+		}
+		if strings.Contains(v, "{{") || strings.Contains(v, "{%") || strings.Contains(v, "{#") {
+			return fmt.Errorf("extra_vars %q must not contain Jinja delimiters", k)
+		}
+	}
+	return nil
+}
+
+// tagRe is a playbook tag; it can't start with '-' (read as a flag) or hold ','
+// (the list separator).
+var tagRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+func validateTags(field string, tags []string) error {
+	for _, t := range tags {
+		if !tagRe.MatchString(t) {
+			return fmt.Errorf("invalid %s entry %q", field, t)
+		}
+	}
+	return nil
+}
+
+// writeInventory writes the inventory (and the files it refers to) for one handled
+// message. The run ID in the name keeps concurrent runs of the same id and name,
+// e.g. a redelivery on another handler, from sharing or deleting each other's files.
+func writeInventory(r InstallRequest, runID string) (string, error) {
+	if err := os.MkdirAll(cfg.InventoryDir, 0o755); err != nil {
 		return "", fmt.Errorf("create inventories dir: %w", err)
 	}
 
 	sanitized := sanitizeName(r.Name) // e.g., "db_postgresql_hiteman_prod"
-	filename := fmt.Sprintf("vm_%d_%s.ini", r.ID, sanitized)
-	path := filepath.Join(inventoryDir, filename)
+	ext := ".ini"
+	if cfg.InventoryFormat == "yaml" {
+		ext = ".yml" // ansible picks the inventory plugin by extension
+	}
+	filename := fmt.Sprintf("vm_%d_%s_%s%s", r.ID, sanitized, runID, ext)
+	path := filepath.Join(cfg.InventoryDir, filename)
 
-	// Inventory entry (single host line)
-	// Example:
-	// 10.2.0.61 ansible_user=root ansible_password=P@ssw0rd123!! db_name=app_db db_user=appUser db_password=appPassword
-	line := fmt.Sprintf("%s ansible_user=%s ansible_password=%s db_name=%s db_user=%s db_password=%s\n",
-		r.IPAddress, r.VMUser, r.VMPassword, r.DBName, r.DBUser, r.DBPassword)
+	// files the inventory refers to go first; removeInventory cleans up all of them
+	companions := []struct{ path, content, what string }{
+		{r.keyFileFor(path), r.SSHPrivateKey, "ssh key"},
+		{r.knownHostsFileFor(path), r.KnownHosts, "known_hosts"},
+		{r.vaultPasswordFileFor(path), r.VaultPassword, "vault password"},
+		{r.vaultVarsFileFor(path), r.vaultExtraVars(), "vault vars"},
+	}
+	for _, c := range companions {
+		if c.path == "" {
+			continue
+		}
+		content := c.content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n" // ssh rejects a key without the final newline; keep files line-terminated
+		}
+		activeInventories.Store(c.path, struct{}{})
+		if err := withUmask(func() error { return writeNewFile(c.path, []byte(content)) }); err != nil {
+			removeCompanionFiles(path)
+			return path, fmt.Errorf("write %s file: %w", c.what, err)
+		}
+	}
+
+	line, _ := renderInventory(r, path)
+	if err := withUmask(func() error { return writeInventoryFile(path, line) }); err != nil {
+		removeCompanionFiles(path)
+		return path, err
+	}
+	activeInventories.Store(path, struct{}{})
 
-	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
-		return path, fmt.Errorf("write inventory file: %w", err)
+	// redacted copy survives the deferred removal of the original, for debugging
+	if cfg.DebugInventoryDir != "" {
+		var bak string
+		err := withUmask(func() (err error) {
+			bak, err = backupInventory(cfg.DebugInventoryDir, path, line)
+			return err
+		})
+		if err != nil {
+			slog.Warn("debug inventory copy failed", "err", err)
+		} else {
+			slog.Debug("redacted inventory copy", "path", bak)
+		}
 	}
 	return path, nil
 }
 
+// hostVar is one inventory variable of the target host.
+type hostVar struct {
+	name, value string
+	bare        bool // emitted unquoted (ports, booleans)
+	vault       bool // an ansible-vault encrypted string; YAML inventories only
+}
+
+// renderInventory builds the inventory in INVENTORY_FORMAT, one host per target,
+// along with the names of the host vars it set (in order).
+func renderInventory(r InstallRequest, invPath string) (content string, names []string) {
+	var b strings.Builder
+	if cfg.InventoryFormat == "yaml" {
+		b.WriteString("all:\n  hosts:\n")
+	}
+	seen := map[string]bool{}
+	for _, t := range r.targets() {
+		host, vars := inventoryHost(t.IPAddress), inventoryVars(r.forHost(t), invPath)
+		for _, v := range vars {
+			if !seen[v.name] {
+				seen[v.name] = true
+				names = append(names, v.name)
+			}
+		}
+		if cfg.InventoryFormat == "yaml" {
+			b.WriteString(inventoryYAMLHost(host, vars))
+		} else {
+			b.WriteString(inventoryLine(host, vars))
+		}
+	}
+	return b.String(), names
+}
+
+// inventoryLine renders a host line of an INI inventory. Values are
+// double-quoted (except ports and booleans) so spaces, '#' or '=' can't split or cut the line.
+// Example:
+// 10.2.0.61 ansible_user="root" ansible_password="P@ss w0rd#1" db_name="app_db" db_port=5432
+func inventoryLine(host string, vars []hostVar) string {
+	line := host
+	for _, v := range vars {
+		val := iniQuote(v.value)
+		if v.bare {
+			val = v.value
+		}
+		line += " " + v.name + "=" + val
+	}
+	return line + "\n"
+}
+
+// iniQuote double-quotes s for an INI host line, which ansible splits like a POSIX
+// shell: inside the quotes only '\' and '"' need escaping. Line breaks can't be
+// quoted; validateTarget rejects them.
+func iniQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// inventoryYAMLHost renders one host entry of a YAML inventory, which lists the
+// hosts under all.hosts. Values are emitted as double-quoted scalars (JSON string
+// syntax is valid YAML), except ports and booleans.
+// Example:
+//
+//	all:
+//	  hosts:
+//	    "10.2.0.61":
+//	      ansible_user: "root"
+//	      db_port: 5432
+func inventoryYAMLHost(host string, vars []hostVar) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "    %s:\n", yamlQuote(host))
+	for _, v := range vars {
+		if v.vault {
+			fmt.Fprintf(&b, "      %s: %s", v.name, vaultYAML(v.value, "        "))
+			continue
+		}
+		val := yamlQuote(v.value)
+		if v.bare {
+			val = v.value
+		}
+		fmt.Fprintf(&b, "      %s: %s\n", v.name, val)
+	}
+	return b.String()
+}
+
+// yamlQuote returns s as a double-quoted YAML scalar.
+func yamlQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// inventoryVars lists the host vars for a request whose inventory is at invPath. With
+// an ssh_private_key, SSH uses the key file next to it instead of a password; with
+// known_hosts, the host key is checked against the known_hosts file next to it.
+func inventoryVars(r InstallRequest, invPath string) (vars []hostVar) {
+	keyPath, knownHosts := r.keyFileFor(invPath), r.knownHostsFileFor(invPath)
+	add := func(name, value string) {
+		vars = append(vars, hostVar{name: name, value: value})
+	}
+	// the passwords may come vault-encrypted (see validateVault)
+	addSecret := func(name, value string) {
+		vars = append(vars, hostVar{name: name, value: value, vault: isVaultValue(value)})
+	}
+	addPort := func(name string, port int) {
+		vars = append(vars, hostVar{name: name, value: strconv.Itoa(port), bare: true})
+	}
+	if r.ConnectAddress != "" {
+		add("ansible_host", r.ConnectAddress)
+	}
+	if r.Port != 0 {
+		addPort("ansible_port", r.Port)
+	}
+	add("ansible_user", r.VMUser)
+	if keyPath != "" {
+		add("ansible_ssh_private_key_file", keyPath)
+	} else {
+		addSecret("ansible_password", r.VMPassword)
+	}
+	if args := sshCommonArgs(r, knownHosts); args != "" {
+		add("ansible_ssh_common_args", args)
+	}
+	if r.BecomePassword != "" {
+		vars = append(vars, hostVar{name: "ansible_become", value: "true", bare: true})
+		add("ansible_become_method", cmp.Or(r.BecomeMethod, becomeMethods[0]))
+		addSecret("ansible_become_password", r.BecomePassword)
+	}
+	// db vars are absent for facts-only requests
+	if r.DBName != "" {
+		add("db_name", r.DBName)
+		add("db_user", r.DBUser)
+		addSecret("db_password", r.DBPassword)
+	}
+	if r.DBPort != 0 {
+		addPort("db_port", r.DBPort)
+	}
+	return vars
+}
+
+// sshCommonArgs are the extra ssh options for the request: host key checking against
+// knownHosts, if set, the ProxyCommand through its bastion, then the request's own
+// ssh_common_args. ansible splits them shell-style, hence the quoting.
+func sshCommonArgs(r InstallRequest, knownHosts string) string {
+	return strings.TrimSpace(workerSSHArgs(r, knownHosts) + " " + strings.Join(strings.Fields(r.SSHCommonArgs), " "))
+}
+
+// workerSSHArgs are the ssh options the worker sets itself. ssh takes the first
+// value of each option, though validateSSHCommonArgs keeps requests off these anyway.
+func workerSSHArgs(r InstallRequest, knownHosts string) string {
+	var hostKeyOpts string
+	if knownHosts != "" {
+		hostKeyOpts = "-o UserKnownHostsFile='" + knownHosts + "' -o StrictHostKeyChecking=yes"
+	}
+	if r.BastionHost == "" {
+		return hostKeyOpts
+	}
+
+	// the bastion's own key is checked the same way
+	proxy := "ssh -o BatchMode=yes "
+	if hostKeyOpts != "" {
+		proxy += hostKeyOpts + " "
+	}
+	proxy += fmt.Sprintf("-W %%h:%%p -p %d ", cmp.Or(r.BastionPort, defaultSSHPort))
+	if r.BastionUser != "" {
+		proxy += r.BastionUser + "@"
+	}
+	proxy += inventoryHost(r.BastionHost)
+	return strings.TrimSpace(hostKeyOpts + ` -o ProxyCommand="` + proxy + `"`)
+}
+
+// writeInventoryFile puts the inventory at path, as a named pipe when INVENTORY_FIFO is set.
+func writeInventoryFile(path, content string) error {
+	if cfg.InventoryFIFO {
+		err := serveInventoryFIFO(path, []byte(content))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errFIFOUnsupported) {
+			return err
+		}
+		slog.Warn("inventory fifo unavailable, writing a regular file", "err", err)
+	}
+
+	if err := writeNewFile(path, []byte(content)); err != nil {
+		return fmt.Errorf("write inventory file: %w", err)
+	}
+	return nil
+}
+
+// writeNewFile writes data to a 0600 file that must not exist yet, so two runs can
+// never end up writing the same file.
+func writeNewFile(path string, data []byte) error {
+	f, err := createFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path) // a partial file would block the retry
+	}
+	return err
+}
+
+// dbTypes lists the requested db_types: db_types if given, else the single db_type.
+func (r InstallRequest) dbTypes() []string {
+	if len(r.DBTypes) > 0 {
+		return r.DBTypes
+	}
+	return []string{r.DBType}
+}
+
+// playTimeout is the playbook timeout for this request: timeout_seconds if set
+// (at most maxPlayTimeout), else the default playTimeout.
+func (r InstallRequest) playTimeout() time.Duration {
+	if r.TimeoutSeconds <= 0 {
+		return playTimeout
+	}
+	return min(time.Duration(r.TimeoutSeconds)*time.Second, maxPlayTimeout)
+}
+
+// sshPort is the SSH port to dial: port if set, else 22.
+func (r InstallRequest) sshPort() int {
+	if r.Port != 0 {
+		return r.Port
+	}
+	return defaultSSHPort
+}
+
+// sshAddress is where the VM is actually dialed: connect_address if set, else ip_address.
+func (r InstallRequest) sshAddress() string {
+	if r.ConnectAddress != "" {
+		return r.ConnectAddress
+	}
+	return r.IPAddress
+}
+
+// deferForFullDisk reports the request as deferred by a full inventory dir and
+// sweeps stale inventories to make room for the retry.
+func deferForFullDisk(req InstallRequest, publish func(InstallStatus)) {
+	slog.Warn("inventory dir full, sweeping stale inventories and retrying", "id", req.ID)
+	publish(InstallStatus{
+		ID: req.ID, Name: req.Name, Stage: stageDeferred, Status: stageDeferred,
+		Error: "inventory dir full, retrying", ErrorCode: errCodeNoSpace, Timestamp: time.Now(),
+	})
+	sweepInventories(cfg.InventoryDir, cfg.StaleInventoryAge)
+}
+
+// retryInventoryOnFullDisk handles ENOSPC from writeInventory: it reports the request
+// as deferred, frees space by sweeping stale inventories and retries a few times
+// before giving up with NO_SPACE.
+func retryInventoryOnFullDisk(ctx context.Context, req InstallRequest, runID string, publish func(InstallStatus)) (string, error) {
+	deferForFullDisk(req, publish)
+
+	var err error
+	for attempt := 1; attempt <= diskFullRetries; attempt++ {
+		if attempt > 1 {
+			sweepInventories(cfg.InventoryDir, cfg.StaleInventoryAge)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(diskFullRetryDelay):
+		}
+		var path string
+		if path, err = writeInventory(req, runID); err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, syscall.ENOSPC) {
+			return "", err
+		}
+		slog.Warn("inventory dir still full", "id", req.ID, "attempt", attempt, "retries", diskFullRetries)
+	}
+	return "", fmt.Errorf("%w (gave up after %d retries)", err, diskFullRetries)
+}
+
+// removeInventory deletes a written inventory so secrets don't linger on disk.
+func removeInventory(p string) {
+	if p == "" {
+		return
+	}
+	defer activeInventories.Delete(p)
+	stopInventoryFIFO(p)
+	if rmErr := os.Remove(p); rmErr != nil {
+		slog.Warn("remove inventory failed", "path", p, "err", rmErr)
+	} else {
+		slog.Info("removed inventory", "path", p)
+	}
+	removeCompanionFiles(p)
+}
+
+// removeCompanionFiles deletes the private key and known_hosts written next to the
+// inventory at p, if the request had them.
+func removeCompanionFiles(p string) {
+	for _, f := range []string{sshKeyPath(p), knownHostsPath(p), vaultPasswordPath(p), vaultVarsPath(p)} {
+		if rmErr := os.Remove(f); rmErr == nil {
+			slog.Info("removed inventory companion file", "path", f)
+		} else if !errors.Is(rmErr, fs.ErrNotExist) {
+			slog.Warn("remove inventory companion file failed", "path", f, "err", rmErr)
+		}
+		activeInventories.Delete(f)
+	}
+}
+
+// sshKeyPath is where the private key for the inventory at invPath is written.
+func sshKeyPath(invPath string) string {
+	return strings.TrimSuffix(invPath, filepath.Ext(invPath)) + ".key"
+}
+
+// keyFileFor returns the private key file the inventory at invPath refers to, or
+// "" when the request authenticates with a password.
+func (r InstallRequest) keyFileFor(invPath string) string {
+	if r.SSHPrivateKey == "" {
+		return ""
+	}
+	return sshKeyPath(invPath)
+}
+
+// knownHostsPath is the known_hosts file written next to the inventory at invPath.
+func knownHostsPath(invPath string) string {
+	return strings.TrimSuffix(invPath, filepath.Ext(invPath)) + ".known_hosts"
+}
+
+// knownHostsFileFor returns the known_hosts file the inventory at invPath refers to,
+// or "" when the request didn't send known_hosts.
+func (r InstallRequest) knownHostsFileFor(invPath string) string {
+	if r.KnownHosts == "" {
+		return ""
+	}
+	return knownHostsPath(invPath)
+}
+
+// hostKeyEnv is the ANSIBLE_HOST_KEY_CHECKING to run the request's ansible with.
+// A request with known_hosts always checks: ansible.cfg may switch checking off, and
+// the StrictHostKeyChecking=no ansible then passes would win over ours. Otherwise the
+// worker's setting is passed on, or nothing and ansible.cfg decides.
+func (r InstallRequest) hostKeyEnv() []string {
+	switch {
+	case r.KnownHosts != "":
+		return []string{"ANSIBLE_HOST_KEY_CHECKING=True"}
+	case cfg.HostKeyChecking != "":
+		return []string{"ANSIBLE_HOST_KEY_CHECKING=" + cfg.HostKeyChecking}
+	}
+	return nil
+}
+
 // sanitizeName converts "DB PostgreSQL HiTeman Prod" => "db_postgresql_hiteman_prod"
 func sanitizeName(name string) string {
 	s := strings.ToLower(strings.TrimSpace(name))
@@ -257,32 +1475,77 @@ func sanitizeName(name string) string {
 	return s
 }
 
-func selectPlaybook(dbType string) (string, error) {
-	switch strings.ToLower(strings.TrimSpace(dbType)) {
-	case "postgresql", "postgres", "pg":
-		return "playbooks/postgresql.yml", nil
-	// Add other DBs here when ready:
-	// case "mysql":
-	//     return "playbooks/mysql.yml", nil
-	// case "mariadb":
-	//     return "playbooks/mariadb.yml", nil
-	default:
-		return "", fmt.Errorf("unsupported db_type %q", dbType)
+// playbookArgs builds the ansible-playbook argv (without the binary name) for r.
+func playbookArgs(inventoryPath, playbookPath string, r InstallRequest) []string {
+	args := append([]string{"-i", inventoryPath, playbookPath}, r.vaultArgs(inventoryPath)...)
+	if r.CheckMode && !slices.Contains(r.ExtraArgs, "--check") {
+		args = append(args, "--check")
+	}
+	if r.Verbosity > 0 {
+		args = append(args, "-"+strings.Repeat("v", r.Verbosity))
 	}
+	tags := r.Tags
+	if r.Action == actionReconfigure && r.PlaybookPath == "" && !isReconfigurePlaybook(playbookPath) {
+		tags = append(slices.Clone(tags), reconfigureTag)
+	}
+	if len(tags) > 0 {
+		args = append(args, "--tags", strings.Join(tags, ","))
+	}
+	if len(r.SkipTags) > 0 {
+		args = append(args, "--skip-tags", strings.Join(r.SkipTags, ","))
+	}
+	return append(args, r.ExtraArgs...)
 }
 
-func runPlaybook(parent context.Context, inventoryPath, playbookPath string) (exitCode int, output []byte, err error) {
+// runPlaybook runs ansible-playbook with args; env entries (KEY=value) are added
+// to the worker's own environment. logPrefix tags each line streamed to stdout.
+func runPlaybook(parent context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (exitCode int, output []byte, err error) {
 	if _, statErr := os.Stat(playbookPath); statErr != nil {
 		return 127, nil, fmt.Errorf("playbook not found at %s: %w", playbookPath, statErr)
 	}
 
-	ctx, cancel := context.WithTimeout(parent, playTimeout)
+	return runAnsible(parent, cfg.AnsiblePlaybookBin, args, env, timeout, logPrefix)
+}
+
+// ansibleBin is the ad-hoc `ansible` CLI.
+func ansibleBin() string {
+	return ansibleTool("ansible")
+}
+
+// ansibleTool is another ansible CLI (ansible, ansible-galaxy, ...): next to
+// ANSIBLE_PLAYBOOK_BIN when that is a path (e.g. a virtualenv's bin/), else looked
+// up in PATH.
+func ansibleTool(name string) string {
+	if filepath.Base(cfg.AnsiblePlaybookBin) == cfg.AnsiblePlaybookBin {
+		return name
+	}
+	return filepath.Join(filepath.Dir(cfg.AnsiblePlaybookBin), name)
+}
+
+// runAnsible runs an ansible CLI (ansible-playbook, ansible, ...) with a timeout,
+// streaming its output to stdout while capturing it.
+func runAnsible(parent context.Context, bin string, args, env []string, timeout time.Duration, logPrefix string) (exitCode int, output []byte, err error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "ansible-playbook", "-i", inventoryPath, playbookPath)
+	cmd := exec.CommandContext(ctx, bin, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	killGroupOnCancel(cmd)
+	// a leftover child holding stdout open mustn't keep Wait from returning
+	cmd.WaitDelay = ansibleWaitDelay
 
 	var buf bytes.Buffer
-	mw := io.MultiWriter(&buf, os.Stdout) // stream to journald + capture
+	var stdout io.Writer = os.Stdout
+	if logPrefix != "" {
+		stdout = &prefixWriter{w: os.Stdout, prefix: []byte(logPrefix)}
+	}
+	writers := []io.Writer{&buf, stdout} // stream to journald + capture (unprefixed)
+	if tap := outputTap(ctx); tap != nil {
+		writers = append(writers, tap)
+	}
+	mw := io.MultiWriter(writers...)
 	cmd.Stdout = mw
 	cmd.Stderr = mw
 
@@ -291,8 +1554,15 @@ func runPlaybook(parent context.Context, inventoryPath, playbookPath string) (ex
 	code := 0
 	if runErr != nil {
 		var exitErr *exec.ExitError
-		if errors.Is(runErr, context.DeadlineExceeded) {
-			return 124, buf.Bytes(), fmt.Errorf("ansible-playbook timed out after %s", playTimeout)
+		// the killed process only reports "signal: killed"; the context knows why
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 124, buf.Bytes(), fmt.Errorf("%s timed out after %s", bin, timeout)
+		}
+		if errors.Is(context.Cause(ctx), errCancelled) {
+			return exitCancelled, buf.Bytes(), fmt.Errorf("%s killed: %w", bin, errCancelled)
+		}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return 1, buf.Bytes(), fmt.Errorf("%s killed: %w", bin, errShutdown)
 		}
 		if errors.As(runErr, &exitErr) {
 			code = exitErr.ExitCode()
@@ -305,17 +1575,116 @@ func runPlaybook(parent context.Context, inventoryPath, playbookPath string) (ex
 	return 0, buf.Bytes(), nil
 }
 
-func publishStatus(nc *nats.Conn, st InstallStatus) {
+// publishStatus broadcasts st on db.install.status and, when reply is set, also
+// sends it to that inbox for request/reply callers.
+func publishStatus(nc *nats.Conn, st InstallStatus, reply string) {
+	st.WorkerID = cfg.WorkerID
+	if st.Status == "error" {
+		st.ErrorKind = errorKind(st.Category)
+	}
+	if st.Category == catCancelled && st.AnsibleExitCode == 0 {
+		// cancelled before ansible ran, e.g. waiting for the host lock or SSH
+		st.AnsibleExitCode, st.ExitReason = exitCancelled, exitReason(exitCancelled, nil)
+	}
 	data, err := json.Marshal(st)
 	if err != nil {
-		log.Printf("[error] marshal status failed: %v", err)
+		slog.Error("marshal status failed", "id", st.ID, "err", err)
 		return
 	}
-	if err := nc.Publish(subjectInstallStatus, data); err != nil {
-		log.Printf("[error] publish status failed: %v", err)
+
+	// Too big for the server: ship the output as sequenced chunks and reference them
+	if maxPayload := nc.MaxPayload(); maxPayload > 0 && int64(len(data)) > maxPayload && st.AnsibleOutput != "" {
+		n, err := publishOutputChunks(nc, st, maxPayload)
+		if err != nil {
+			slog.Error("publish output chunk failed", "id", st.ID, "chunk", n, "err", err)
+		}
+		st.AnsibleOutput = ""
+		st.OutputChunks = n
+		if data, err = json.Marshal(st); err != nil {
+			slog.Error("marshal status failed", "id", st.ID, "err", err)
+			return
+		}
+	}
+
+	// Everyone can read the broadcast subject, so only the caller's own inbox gets
+	// the connection string with its password
+	replyData := data
+	if st.ConnectionString != "" {
+		st.ConnectionString = redactConnectionString(st.ConnectionString)
+		if data, err = json.Marshal(st); err != nil {
+			slog.Error("marshal status failed", "id", st.ID, "err", err)
+			return
+		}
+	}
+
+	out := nats.NewMsg(cfg.SubjectStatus)
+	out.Data = data
+	if st.RunID != "" {
+		// Stable per (run, stage) so JetStream dedup or clients can drop redeliveries
+		out.Header.Set(nats.MsgIdHdr, statusMsgID(st))
+	}
+	if err := publishOrBuffer(nc, out); err != nil {
+		slog.Error("publish status failed", "id", st.ID, "err", err)
 		return
 	}
-	log.Printf("[status] published: id=%d name=%q status=%s exit=%d", st.ID, st.Name, st.Status, st.AnsibleExitCode)
+	slog.Info("status published", "id", st.ID, "name", st.Name, "stage", st.Stage, "status", st.Status, "exit_code", st.AnsibleExitCode)
+
+	if reply != "" {
+		if err := publishOrBuffer(nc, &nats.Msg{Subject: reply, Data: replyData}); err != nil {
+			slog.Warn("reply with status failed", "id", st.ID, "err", err)
+		}
+	}
+}
+
+// runSummary is the one-line JSON record printed per run when SUMMARY_LINE=true.
+type runSummary struct {
+	Event      string         `json:"event"`
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Status     string         `json:"status"`
+	ExitCode   int            `json:"exit_code"`
+	DurationMs int64          `json:"duration_ms"`
+	Recap      map[string]int `json:"recap,omitempty"`
+}
+
+// printSummary writes a parseable per-run record to stdout for log scrapers.
+func printSummary(st InstallStatus, d time.Duration) {
+	data, err := json.Marshal(runSummary{
+		Event:      "run_summary",
+		ID:         st.ID,
+		Name:       st.Name,
+		Status:     st.Status,
+		ExitCode:   st.AnsibleExitCode,
+		DurationMs: d.Milliseconds(),
+		Recap:      st.RecapStats,
+	})
+	if err != nil {
+		slog.Error("marshal run summary failed", "err", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// newRunID returns a random identifier for one handled message.
+func newRunID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusMsgID is the Nats-Msg-Id of a status: the same for a retried publish of
+// one stage, different for every other (run, stage[, db_type][, seq]).
+func statusMsgID(st InstallStatus) string {
+	id := st.RunID + "." + st.Stage
+	if st.DBType != "" {
+		id += "." + st.DBType
+	}
+	if st.Seq != 0 {
+		id += "." + strconv.Itoa(st.Seq)
+	}
+	return id
 }
 
 func envOr(k, def string) string {
@@ -327,27 +1696,37 @@ func envOr(k, def string) string {
 
 func mustNoErr(err error, msg string) {
 	if err != nil {
-		log.Fatalf("%s: %v", msg, err)
+		slog.Error(msg, "err", err)
+		os.Exit(1)
 	}
 }
 
+// noHostsMatched reports ansible's "skipping: no hosts matched" / "No hosts matched" condition.
+func noHostsMatched(output []byte) bool {
+	return bytes.Contains(bytes.ToLower(output), []byte("no hosts matched"))
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
 	}
-	return s[:max] + "\n...[truncated]..."
+	// back up to a rune start so a multi-byte character isn't cut in half
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "\n...[truncated]..."
 }
 
 // ---- connectivity waiters ----
-func waitForSSH(parent context.Context, ip string) error {
-	port := 22
+func waitForSSH(parent context.Context, ip string, port int) error {
 	addr := net.JoinHostPort(ip, strconv.Itoa(port))
 
 	dialTO := 3 * time.Second   // per-attempt timeout
 	interval := 2 * time.Second // pause between retries
 	heartbeat := 30 * time.Second
 
-	log.Printf("[wait] probing SSH at %s (no max timeout, dialTO=%s, interval=%s)", addr, dialTO, interval)
+	slog.Info("probing SSH", "addr", addr, "dial_timeout", dialTO, "interval", interval)
 
 	nextHeartbeat := time.Now().Add(heartbeat)
 
@@ -355,7 +1734,7 @@ func waitForSSH(parent context.Context, ip string) error {
 		// abort if caller cancelled
 		select {
 		case <-parent.Done():
-			return fmt.Errorf("cancelled while waiting for SSH: %w", parent.Err())
+			return fmt.Errorf("cancelled while waiting for SSH: %w", context.Cause(parent))
 		default:
 		}
 
@@ -363,16 +1742,33 @@ func waitForSSH(parent context.Context, ip string) error {
 		c, err := net.DialTimeout("tcp", addr, dialTO)
 		if err == nil {
 			_ = c.Close()
-			log.Printf("[wait] SSH reachable at %s", addr)
+			slog.Info("SSH reachable", "addr", addr)
 			return nil
 		}
 
 		// periodic heartbeat log
 		if time.Now().After(nextHeartbeat) {
-			log.Printf("[wait] still waiting for SSH at %s (last error: %v)", addr, err)
+			slog.Info("still waiting for SSH", "addr", addr, "err", err)
 			nextHeartbeat = time.Now().Add(heartbeat)
 		}
 
 		time.Sleep(interval)
 	}
 }
+
+// preflight resolves the host and makes a single TCP dial to port within timeout.
+func preflight(parent context.Context, host string, port int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	_ = c.Close()
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// durationBuckets are the install_duration_seconds upper bounds; installs take minutes.
+var durationBuckets = []float64{30, 60, 120, 300, 600, 900, 1200, 1800, 3600, 7200}
+
+// metrics holds the counters behind METRICS_ADDR. They are always updated (cheaply);
+// only the endpoint is optional.
+var metrics = struct {
+	mu            sync.Mutex
+	installs      map[string]uint64 // final statuses by status
+	buckets       []uint64          // cumulative counts per durationBuckets entry
+	durationSum   float64
+	durationCount uint64
+
+	running atomic.Int64 // playbooks running now
+}{
+	installs: map[string]uint64{},
+	buckets:  make([]uint64, len(durationBuckets)),
+}
+
+// observeInstall counts a final install status and, if a playbook ran, its
+// duration (the status's duration_ms).
+func observeInstall(st InstallStatus) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.installs[st.Status]++
+	if st.StartedAt == nil {
+		return
+	}
+	secs := float64(st.DurationMs) / 1000
+	for i, le := range durationBuckets {
+		if secs <= le {
+			metrics.buckets[i]++
+		}
+	}
+	metrics.durationSum += secs
+	metrics.durationCount++
+}
+
+// writeMetrics renders the metrics in the Prometheus text exposition format. The
+// jetstream_* gauges are there when backlog is (JETSTREAM=true).
+func writeMetrics(w io.Writer, backlog *Backlog) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP installs_total Install requests finished, by final status.")
+	fmt.Fprintln(w, "# TYPE installs_total counter")
+	statuses := make([]string, 0, len(metrics.installs))
+	for s := range metrics.installs {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(w, "installs_total{status=%q} %d\n", s, metrics.installs[s])
+	}
+
+	fmt.Fprintln(w, "# HELP install_duration_seconds Time from the first playbook's start to the last one's end.")
+	fmt.Fprintln(w, "# TYPE install_duration_seconds histogram")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(w, "install_duration_seconds_bucket{le=\"%g\"} %d\n", le, metrics.buckets[i])
+	}
+	fmt.Fprintf(w, "install_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.durationCount)
+	fmt.Fprintf(w, "install_duration_seconds_sum %g\n", metrics.durationSum)
+	fmt.Fprintf(w, "install_duration_seconds_count %d\n", metrics.durationCount)
+
+	fmt.Fprintln(w, "# HELP playbooks_running Playbooks running right now.")
+	fmt.Fprintln(w, "# TYPE playbooks_running gauge")
+	fmt.Fprintf(w, "playbooks_running %d\n", metrics.running.Load())
+
+	fmt.Fprintln(w, "# HELP playbooks_queued Playbook runs waiting for a MAX_CONCURRENT_RUNS slot.")
+	fmt.Fprintln(w, "# TYPE playbooks_queued gauge")
+	queued := 0
+	if runSlots != nil {
+		queued = runSlots.queued()
+	}
+	fmt.Fprintf(w, "playbooks_queued %d\n", queued)
+
+	if backlog == nil {
+		return
+	}
+	fmt.Fprintln(w, "# HELP jetstream_consumer_pending Install requests not yet delivered to any worker.")
+	fmt.Fprintln(w, "# TYPE jetstream_consumer_pending gauge")
+	fmt.Fprintf(w, "jetstream_consumer_pending %d\n", backlog.Pending)
+	fmt.Fprintln(w, "# HELP jetstream_consumer_ack_pending Install requests delivered but not acked yet.")
+	fmt.Fprintln(w, "# TYPE jetstream_consumer_ack_pending gauge")
+	fmt.Fprintf(w, "jetstream_consumer_ack_pending %d\n", backlog.AckPending)
+}
+
+// startMetricsServer serves /metrics on addr until ctx is done.
+func startMetricsServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		// ask the server before taking the lock, and render first so a slow
+		// scraper doesn't hold it
+		backlog := jetStreamBacklog()
+		var buf bytes.Buffer
+		writeMetrics(&buf, backlog)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("metrics listening", "addr", addr, "path", "/metrics")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics.mu.Lock()
+	metrics.installs = map[string]uint64{}
+	metrics.buckets = make([]uint64, len(durationBuckets))
+	metrics.durationSum, metrics.durationCount = 0, 0
+	metrics.mu.Unlock()
+	metrics.running.Store(1)
+	runSlots = newAdmission(1)
+	defer metrics.running.Store(0)
+
+	now := time.Now()
+	for _, st := range []InstallStatus{
+		{Status: "success", StartedAt: &now, DurationMs: 45_000},
+		{Status: "success", StartedAt: &now, DurationMs: 400_000},
+		{Status: "error", StartedAt: &now, DurationMs: 90_000},
+		{Status: "error"}, // rejected before a playbook ran
+	} {
+		observeInstall(st)
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, &Backlog{Pending: 4, AckPending: 2})
+
+	for _, want := range []string{
+		`installs_total{status="error"} 2`,
+		`installs_total{status="success"} 2`,
+		`install_duration_seconds_bucket{le="30"} 0`,
+		`install_duration_seconds_bucket{le="60"} 1`,
+		`install_duration_seconds_bucket{le="120"} 2`,
+		`install_duration_seconds_bucket{le="600"} 3`,
+		`install_duration_seconds_bucket{le="+Inf"} 3`,
+		`install_duration_seconds_sum 535`,
+		`install_duration_seconds_count 3`,
+		`playbooks_running 1`,
+		`playbooks_queued 0`,
+		`jetstream_consumer_pending 4`,
+		`jetstream_consumer_ack_pending 2`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics lack %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteMetricsCoreNATS(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, nil)
+	if strings.Contains(buf.String(), "jetstream_") {
+		t.Errorf("jetstream gauges without JetStream:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsAuthOptions builds the credentials and TLS options from the NATS_* settings.
+// Files are checked up front so a bad path fails startup with a clear message
+// instead of an opaque connect error. With nothing set it returns no options.
+func natsAuthOptions(c Config) ([]nats.Option, error) {
+	var opts []nats.Option
+	if c.NatsCreds != "" {
+		if _, err := os.ReadFile(c.NatsCreds); err != nil {
+			return nil, fmt.Errorf("NATS_CREDS: %w", err)
+		}
+		opts = append(opts, nats.UserCredentials(c.NatsCreds))
+	}
+	if c.NatsToken != "" {
+		opts = append(opts, nats.Token(c.NatsToken))
+	}
+	if c.NatsTLSCA != "" {
+		if _, err := os.ReadFile(c.NatsTLSCA); err != nil {
+			return nil, fmt.Errorf("NATS_TLS_CA: %w", err)
+		}
+		opts = append(opts, nats.RootCAs(c.NatsTLSCA))
+	}
+	if (c.NatsTLSCert == "") != (c.NatsTLSKey == "") {
+		return nil, errors.New("NATS_TLS_CERT and NATS_TLS_KEY must be set together")
+	}
+	if c.NatsTLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(c.NatsTLSCert, c.NatsTLSKey); err != nil {
+			return nil, fmt.Errorf("NATS_TLS_CERT/NATS_TLS_KEY: %w", err)
+		}
+		opts = append(opts, nats.ClientCert(c.NatsTLSCert, c.NatsTLSKey))
+	}
+	return opts, nil
+}
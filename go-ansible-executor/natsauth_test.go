@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNatsAuthOptions(t *testing.T) {
+	creds := filepath.Join(t.TempDir(), "worker.creds")
+	if err := os.WriteFile(creds, []byte("-----BEGIN NATS USER JWT-----\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantOpts int
+		wantErr  string
+	}{
+		{name: "anonymous", cfg: Config{}},
+		{name: "token", cfg: Config{NatsToken: "s3cret"}, wantOpts: 1},
+		{name: "creds and token", cfg: Config{NatsCreds: creds, NatsToken: "s3cret"}, wantOpts: 2},
+		{name: "missing creds", cfg: Config{NatsCreds: missing}, wantErr: "NATS_CREDS"},
+		{name: "missing CA", cfg: Config{NatsTLSCA: missing}, wantErr: "NATS_TLS_CA"},
+		{name: "cert without key", cfg: Config{NatsTLSCert: missing}, wantErr: "must be set together"},
+		{name: "bad cert pair", cfg: Config{NatsTLSCert: missing, NatsTLSKey: missing}, wantErr: "NATS_TLS_CERT/NATS_TLS_KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := natsAuthOptions(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(opts) != tt.wantOpts {
+				t.Errorf("got %d options, want %d", len(opts), tt.wantOpts)
+			}
+		})
+	}
+
+	if _, ok := publicConfig(Config{NatsToken: "s3cret"})["nats_token"]; ok {
+		t.Error("publicConfig() includes nats_token")
+	}
+}
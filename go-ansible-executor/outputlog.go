@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeOutputLog keeps the full (redacted) ansible output of a run in dir, as
+// <id>_<UTC timestamp>_<run id>.log, for post-mortems beyond MAX_OUTPUT_BYTES.
+func writeOutputLog(dir string, id int, runID string, started time.Time, output string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create output log dir: %w", err)
+	}
+	name := fmt.Sprintf("%d_%s_%s.log", id, started.UTC().Format("20060102T150405Z"), runID)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(output), 0o600); err != nil {
+		return "", fmt.Errorf("write output log: %w", err)
+	}
+	return path, nil
+}
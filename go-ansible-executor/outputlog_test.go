@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteOutputLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	started := time.Date(2026, 3, 1, 14, 5, 9, 0, time.FixedZone("CET", 3600))
+
+	path, err := writeOutputLog(dir, 7, "run1", started, "PLAY RECAP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "7_20260301T130509Z_run1.log"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "PLAY RECAP\n" {
+		t.Errorf("log = %q", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("log mode = %v (%v), want 0600", fi.Mode().Perm(), err)
+	}
+}
+
+func TestCombinedOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []playResult
+		want    string
+	}{
+		{name: "none"},
+		{name: "one db_type", results: []playResult{{DBType: "postgresql", Output: []byte("pg\n")}}, want: "pg\n"},
+		{
+			name:    "several",
+			results: []playResult{{DBType: "postgresql", Output: []byte("pg\n")}, {DBType: "mysql", Output: []byte("my\n")}},
+			want:    "===== postgresql =====\npg\n\n===== mysql =====\nmy\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := combinedOutput(tt.results); got != tt.want {
+			t.Errorf("%s: combinedOutput() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// subjectLogsPrefix is where stream_output requests publish their live output, as
+// db.install.logs.<id>.
+const subjectLogsPrefix = "db.install.logs"
+
+// maxStreamLine flushes a line that long even without its newline yet.
+const maxStreamLine = 64 << 10
+
+// Headers carried by each db.install.logs.<id> message. Seq orders a run's
+// messages; the last one of each playbook run has Stream-Done set and may be empty.
+const (
+	hdrRunID      = "Run-Id"
+	hdrDBType     = "Db-Type"
+	hdrAttempt    = "Attempt"
+	hdrSeq        = "Seq" // 0-based, per run and db_type
+	hdrStreamDone = "Stream-Done"
+)
+
+// outputStream publishes a playbook's output, masked, as whole lines arrive.
+// Publishing is best effort: nobody may be watching, so nothing is buffered for a
+// reconnect and a failed publish never fails the run.
+type outputStream struct {
+	nc      *nats.Conn
+	subject string
+	id      int
+	runID   string
+	dbType  string
+	attempt int
+	secrets []string
+	longest int // bytes of the longest secret
+
+	mu      sync.Mutex
+	partial []byte // the current line so far
+	seq     int
+	warned  bool
+}
+
+func newOutputStream(nc *nats.Conn, req InstallRequest, runID, dbType string) *outputStream {
+	s := &outputStream{
+		nc:      nc,
+		subject: subjectLogsPrefix + "." + strconv.Itoa(req.ID),
+		id:      req.ID,
+		runID:   runID,
+		dbType:  dbType,
+		secrets: req.secrets(),
+	}
+	for _, secret := range s.secrets {
+		s.longest = max(s.longest, len(secret))
+	}
+	return s
+}
+
+// Write never fails, so it can sit in an io.MultiWriter next to the capture buffer.
+func (s *outputStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, b...)
+	if i := bytes.LastIndexByte(s.partial, '\n'); i >= 0 {
+		s.publish(s.partial[:i+1], false)
+		s.partial = append(s.partial[:0], s.partial[i+1:]...)
+	} else if len(s.partial) >= maxStreamLine {
+		// a secret cut in two here would be masked in neither piece: mask the line
+		// so far, then hold back what may still be the start of one
+		s.partial = redactSecrets(s.partial, s.secrets)
+		cut := len(s.partial) - min(max(s.longest-1, 0), len(s.partial))
+		s.publish(s.partial[:cut], false)
+		s.partial = append(s.partial[:0], s.partial[cut:]...)
+	}
+	return len(b), nil
+}
+
+// startAttempt marks the following output as playbook run attempt n.
+func (s *outputStream) startAttempt(n int) {
+	s.mu.Lock()
+	s.attempt = n
+	s.mu.Unlock()
+}
+
+// end publishes what is left of the output, marked as the end of this attempt.
+func (s *outputStream) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(s.partial, true)
+	s.partial = s.partial[:0]
+}
+
+// publish sends one chunk; s.mu is held.
+func (s *outputStream) publish(chunk []byte, done bool) {
+	msg := nats.NewMsg(s.subject)
+	// redactSecrets may return chunk itself, which s.partial reuses
+	msg.Data = bytes.Clone(redactSecrets(chunk, s.secrets))
+	msg.Header.Set(hdrInstallID, strconv.Itoa(s.id))
+	msg.Header.Set(hdrRunID, s.runID)
+	msg.Header.Set(hdrDBType, s.dbType)
+	msg.Header.Set(hdrAttempt, strconv.Itoa(s.attempt))
+	msg.Header.Set(hdrSeq, strconv.Itoa(s.seq))
+	if done {
+		msg.Header.Set(hdrStreamDone, "true")
+	}
+	s.seq++
+	if err := s.nc.PublishMsg(msg); err != nil && !s.warned {
+		s.warned = true
+		slog.Warn("stream output failed", "id", s.id, "subject", s.subject, "err", err)
+	}
+}
+
+type outputTapKey struct{}
+
+// withOutputTap has runAnsible copy the command's output to w as well.
+func withOutputTap(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputTapKey{}, w)
+}
+
+func outputTap(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(outputTapKey{}).(io.Writer)
+	return w
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputStream(t *testing.T) {
+	nc := startNATS(t)
+	sub, err := nc.SubscribeSync(subjectLogsPrefix + ".7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := testRequest()
+	s := newOutputStream(nc, req, "run1", "postgresql")
+
+	s.startAttempt(1)
+	s.Write([]byte("TASK [create user] ***\nok: [10.0.0.1] password=db-secret\nPLAY"))
+	s.Write([]byte(" RECAP\n10.0.0.1 : ok=2"))
+	s.end()
+	s.startAttempt(2)
+	s.end()
+
+	tests := []struct {
+		data    string
+		attempt string
+		seq     string
+		done    bool
+	}{
+		{data: "TASK [create user] ***\nok: [10.0.0.1] password=***\n", attempt: "1", seq: "0"},
+		{data: "PLAY RECAP\n", attempt: "1", seq: "1"},
+		{data: "10.0.0.1 : ok=2", attempt: "1", seq: "2", done: true},
+		{attempt: "2", seq: "3", done: true},
+	}
+	for _, tt := range tests {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("seq %s: %v", tt.seq, err)
+		}
+		if string(msg.Data) != tt.data {
+			t.Errorf("seq %s: data = %q, want %q", tt.seq, msg.Data, tt.data)
+		}
+		h := msg.Header
+		if h.Get(hdrInstallID) != "7" || h.Get(hdrRunID) != "run1" || h.Get(hdrDBType) != "postgresql" {
+			t.Errorf("seq %s: headers %v", tt.seq, h)
+		}
+		if h.Get(hdrAttempt) != tt.attempt || h.Get(hdrSeq) != tt.seq || (h.Get(hdrStreamDone) == "true") != tt.done {
+			t.Errorf("seq %s: attempt %s seq %s done %q, want %s, %s, %v",
+				tt.seq, h.Get(hdrAttempt), h.Get(hdrSeq), h.Get(hdrStreamDone), tt.attempt, tt.seq, tt.done)
+		}
+	}
+}
+
+func TestOutputStreamLongLines(t *testing.T) {
+	long := strings.Repeat("x", maxStreamLine-3)
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "secret across the cut",
+			writes: []string{long + "db-", "secret, done\n"},
+			want:   long + "***, done\n",
+		},
+		{
+			name:   "secret before the cut",
+			writes: []string{"db-secret" + long, "...\n"},
+			want:   "***" + long + "...\n",
+		},
+		{
+			name:   "secret in a later piece",
+			writes: []string{long + "abc", long, "vm-secret\n"},
+			want:   long + "abc" + long + "***\n",
+		},
+		{
+			name:   "short lines",
+			writes: []string{"ok: [10.0.0.1] db-sec", "ret\nchanged: [10.0.0.1]\n"},
+			want:   "ok: [10.0.0.1] ***\nchanged: [10.0.0.1]\n",
+		},
+	}
+	nc := startNATS(t)
+	sub, err := nc.SubscribeSync(subjectLogsPrefix + ".7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOutputStream(nc, testRequest(), "run1", "postgresql")
+			for _, w := range tt.writes {
+				s.Write([]byte(w))
+			}
+			s.end()
+
+			var got strings.Builder
+			for {
+				msg, err := sub.NextMsg(5 * time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, secret := range []string{"db-secret", "vm-secret"} {
+					if strings.Contains(string(msg.Data), secret) {
+						t.Errorf("message %s holds %q", msg.Header.Get(hdrSeq), secret)
+					}
+				}
+				got.Write(msg.Data)
+				if msg.Header.Get(hdrStreamDone) != "" {
+					break
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("streamed %q...%q (%d bytes), want %q...%q (%d bytes)",
+					head(got.String()), tail(got.String()), got.Len(), head(tt.want), tail(tt.want), len(tt.want))
+			}
+		})
+	}
+}
+
+func head(s string) string { return s[:min(len(s), 20)] }
+func tail(s string) string { return s[max(len(s)-20, 0):] }
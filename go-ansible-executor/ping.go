@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pingTimeout bounds the optional `ansible -m ping` preflight (PREFLIGHT_PING).
+const pingTimeout = 2 * time.Minute
+
+// pingHost runs `ansible all -m ping` against the written inventory. Unlike the TCP
+// preflight it also proves the SSH login and a usable python on the host.
+func pingHost(parent context.Context, req InstallRequest, invPath string) playResult {
+	res := playResult{Args: append([]string{"all", "-i", invPath, "-m", "ping"}, req.vaultArgs(invPath)...)}
+	if err := runSlots.do(parent, req.Priority, func() {
+		res.ExitCode, res.Output, res.Err = runAnsible(parent, ansibleBin(), res.Args, req.hostKeyEnv(), pingTimeout, outputPrefix(req.ID))
+	}); err != nil {
+		res.Err = fmt.Errorf("cancelled while waiting for a run slot: %w", context.Cause(parent))
+	}
+	res.Output = redactSecrets(res.Output, req.secrets())
+	return res
+}
+
+// pingFailure is the final status for a failed ping; "" Status if it succeeded.
+func pingFailure(req InstallRequest, invPath string, r playResult) InstallStatus {
+	if status, _, _ := r.outcome(); status != "error" {
+		return InstallStatus{}
+	}
+	category, errCode := r.category(), errCodeUnreachable
+	msg := fmt.Sprintf("host unreachable: ansible ping failed (exit %d)", r.ExitCode)
+	diag := r.sshDiagnostic()
+	if diag != nil {
+		msg += ": " + diag.Reason
+	}
+	switch category {
+	case catInternal:
+		// ansible itself didn't run; nothing is known about the host
+		msg, errCode = "ansible ping failed: "+r.Err.Error(), ""
+	case catCancelled:
+		msg, errCode = "cancelled during ansible ping", ""
+	}
+	return InstallStatus{
+		ID:              req.ID,
+		Name:            req.Name,
+		Status:          "error",
+		Inventory:       invPath,
+		AnsibleExitCode: r.ExitCode,
+		ExitReason:      exitReason(r.ExitCode, r.Output),
+		CommandLine:     "ansible " + strings.Join(r.Args, " "),
+		AnsibleOutput:   truncate(string(r.Output), cfg.MaxOutputBytes),
+		Error:           msg,
+		ErrorCode:       errCode,
+		Category:        category,
+		SSHDiagnostic:   diag,
+		Timestamp:       time.Now(),
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestPingFailure(t *testing.T) {
+	req := testRequest()
+	exitErr := &exec.ExitError{}
+	tests := []struct {
+		name         string
+		res          playResult
+		wantStatus   string
+		wantError    string
+		wantCode     string
+		wantCategory string
+	}{
+		{name: "pong", res: playResult{Output: []byte(`10.0.0.1 | SUCCESS => {"ping": "pong"}`)}},
+		{
+			name: "permission denied",
+			res: playResult{ExitCode: ansibleExitUnreachable, Err: exitErr, Output: []byte(
+				`10.0.0.1 | UNREACHABLE! => {"msg": "Failed to connect to the host via ssh: admin@10.0.0.1: Permission denied (publickey,password).", "unreachable": true}`)},
+			wantStatus:   "error",
+			wantError:    "host unreachable: ansible ping failed (exit 4): " + sshReasonPermissionDenied,
+			wantCode:     errCodeUnreachable,
+			wantCategory: catAuth,
+		},
+		{
+			name:         "unreachable without a diagnosis",
+			res:          playResult{ExitCode: ansibleExitUnreachable, Err: exitErr},
+			wantStatus:   "error",
+			wantError:    "host unreachable: ansible ping failed (exit 4)",
+			wantCode:     errCodeUnreachable,
+			wantCategory: catNetwork,
+		},
+		{
+			name:         "ansible missing",
+			res:          playResult{ExitCode: -1, Err: errors.New(`exec: "ansible": executable file not found in $PATH`)},
+			wantStatus:   "error",
+			wantError:    `ansible ping failed: exec: "ansible": executable file not found in $PATH`,
+			wantCategory: catInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := pingFailure(req, "inv.ini", tt.res)
+			if st.Status != tt.wantStatus || st.Error != tt.wantError || st.ErrorCode != tt.wantCode || st.Category != tt.wantCategory {
+				t.Errorf("pingFailure() = (%q, %q, %q, %q), want (%q, %q, %q, %q)",
+					st.Status, st.Error, st.ErrorCode, st.Category, tt.wantStatus, tt.wantError, tt.wantCode, tt.wantCategory)
+			}
+		})
+	}
+}
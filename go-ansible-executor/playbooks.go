@@ -0,0 +1,223 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+)
+
+// defaultPlaybooks is the allowlist used when PLAYBOOK_ALLOWLIST_FILE is not set,
+// relative to PLAYBOOK_DIR (default playbooks/).
+var defaultPlaybooks = map[string]string{
+	"postgresql": "postgresql.yml",
+	"mysql":      "mysql.yml",
+	"mariadb":    "mariadb.yml",
+}
+
+// playbookAllowlist maps canonical db_type => playbook path. Reloads swap the whole
+// map, so every selectPlaybook call works on one consistent snapshot.
+var playbookAllowlist atomic.Pointer[map[string]string]
+
+// loadPlaybookAllowlist reads a JSON object of canonical db_type => playbook path.
+// An empty path yields the built-in defaults.
+func loadPlaybookAllowlist(path string) (map[string]string, error) {
+	if path == "" {
+		dir := cmp.Or(cfg.PlaybookDir, "playbooks")
+		m := make(map[string]string, len(defaultPlaybooks))
+		for dbType, pb := range defaultPlaybooks {
+			m[dbType] = filepath.Join(dir, pb)
+		}
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read playbook allowlist: %w", err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse playbook allowlist %s: %w", path, err)
+	}
+	for dbType, pb := range m {
+		if strings.TrimSpace(dbType) == "" || strings.TrimSpace(pb) == "" {
+			return nil, fmt.Errorf("playbook allowlist %s: empty db_type or playbook path", path)
+		}
+		if cfg.PlaybookDir != "" && !filepath.IsAbs(pb) {
+			m[dbType] = filepath.Join(cfg.PlaybookDir, pb)
+		}
+	}
+	return m, nil
+}
+
+// reloadPlaybooks re-reads the allowlist from its source and applies it atomically.
+// On error the previous allowlist stays in effect.
+func reloadPlaybooks() (map[string]string, error) {
+	m, err := loadPlaybookAllowlist(cfg.PlaybookAllowlistFile)
+	if err != nil {
+		return nil, err
+	}
+	playbookAllowlist.Store(&m)
+	slog.Info("playbook allowlist loaded", "playbooks", formatPlaybooks(m))
+	return m, nil
+}
+
+// handleReloadPlaybooks serves the reload control subject and replies with the new list.
+func handleReloadPlaybooks(msg *nats.Msg) {
+	type reply struct {
+		Playbooks map[string]string `json:"playbooks,omitempty"`
+		Error     string            `json:"error,omitempty"`
+	}
+
+	m, err := reloadPlaybooks()
+	r := reply{Playbooks: m}
+	if err != nil {
+		slog.Error("reload playbooks failed", "err", err)
+		r.Error = err.Error()
+	}
+	if msg.Reply == "" {
+		return
+	}
+	data, _ := json.Marshal(r)
+	if err := msg.Respond(data); err != nil {
+		slog.Warn("reply to playbook reload failed", "err", err)
+	}
+}
+
+// validOSFamilies are the os_family values a request may use to pick a specific playbook.
+var validOSFamilies = []string{"debian", "rhel", "suse"}
+
+// selectPlaybook expects a canonical db_type (see normalizeDBType). With an osFamily
+// it prefers an OS-specific sibling of the allowlisted playbook, e.g.
+// playbooks/postgresql_debian.yml, falling back to the generic one if that file doesn't exist.
+// For actionUninstall it uses the "_uninstall" sibling instead (postgresql_uninstall.yml,
+// or postgresql_uninstall_debian.yml), which must exist. For actionReconfigure it uses
+// the "_reconfigure" sibling if there is one; otherwise the install playbook runs with
+// --tags configure (see playbookArgs).
+func selectPlaybook(dbType, osFamily, action string) (string, error) {
+	m := *playbookAllowlist.Load()
+	pb, ok := m[dbType]
+	if !ok {
+		return "", fmt.Errorf("unsupported db_type %q", dbType)
+	}
+	if action == actionUninstall {
+		// the teardown sits next to the allowlisted install playbook
+		var err error
+		if pb, err = siblingPlaybook(pb, "uninstall"); err != nil {
+			return "", fmt.Errorf("no uninstall playbook for db_type %q (%s)", dbType, pb)
+		}
+	}
+	if action == actionReconfigure {
+		if sibling, err := siblingPlaybook(pb, "reconfigure"); err == nil {
+			pb = sibling
+		}
+	}
+	if osFamily != "" {
+		ext := filepath.Ext(pb)
+		specific := strings.TrimSuffix(pb, ext) + "_" + osFamily + ext
+		if _, err := os.Stat(specific); err == nil {
+			return specific, nil
+		}
+	}
+	return pb, nil
+}
+
+// requestPlaybook resolves a request's playbook_path against PLAYBOOK_DIR (default
+// playbooks/). It must name an existing .yml/.yaml file inside that directory, also
+// after following symlinks, so a request can't run an arbitrary file. An _uninstall
+// or _reconfigure playbook only runs for that action, and a _verify one never does:
+// an install must not tear down a database by naming the wrong file.
+func requestPlaybook(p, action string) (string, error) {
+	if !filepath.IsLocal(p) || slices.Contains(strings.Split(filepath.ToSlash(p), "/"), "..") {
+		return "", fmt.Errorf("invalid playbook_path %q (must be relative and stay within the playbook dir)", p)
+	}
+	if ext := filepath.Ext(p); ext != ".yml" && ext != ".yaml" {
+		return "", fmt.Errorf("invalid playbook_path %q (must be a .yml or .yaml file)", p)
+	}
+	base := filepath.Base(p)
+	if strings.Contains(base, "_verify") {
+		return "", fmt.Errorf("invalid playbook_path %q (a _verify playbook only runs as the verify step)", p)
+	}
+	for _, kind := range []string{actionUninstall, actionReconfigure} {
+		if strings.Contains(base, "_"+kind) && action != kind {
+			return "", fmt.Errorf("invalid playbook_path %q (a _%s playbook needs \"action\": %q)", p, kind, kind)
+		}
+	}
+	dir := cmp.Or(cfg.PlaybookDir, "playbooks")
+	pb := filepath.Join(dir, p)
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve playbook dir: %w", err)
+	}
+	realPB, err := filepath.EvalSymlinks(pb)
+	if err != nil {
+		return "", fmt.Errorf("playbook_path %q not found", p)
+	}
+	if rel, err := filepath.Rel(realDir, realPB); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid playbook_path %q (must stay within the playbook dir)", p)
+	}
+	if fi, err := os.Stat(realPB); err != nil || !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("playbook_path %q is not a file", p)
+	}
+	return pb, nil
+}
+
+// verifyPlaybook returns the "_verify" sibling of a canonical db_type's playbook
+// (postgresql_verify.yml), which checks that the installed database takes connections.
+func verifyPlaybook(dbType string) (string, error) {
+	pb, ok := (*playbookAllowlist.Load())[dbType]
+	if !ok {
+		return "", fmt.Errorf("unsupported db_type %q", dbType)
+	}
+	pb, err := siblingPlaybook(pb, "verify")
+	if err != nil {
+		return "", fmt.Errorf("no verify playbook for db_type %q (%s)", dbType, pb)
+	}
+	return pb, nil
+}
+
+// siblingPlaybook is pb with "_"+kind before its extension; an error if that file
+// doesn't exist.
+func siblingPlaybook(pb, kind string) (string, error) {
+	ext := filepath.Ext(pb)
+	sibling := strings.TrimSuffix(pb, ext) + "_" + kind + ext
+	_, err := os.Stat(sibling)
+	return sibling, err
+}
+
+// isReconfigurePlaybook reports whether selectPlaybook picked a dedicated
+// _reconfigure playbook rather than the install one.
+func isReconfigurePlaybook(pb string) bool {
+	return strings.Contains(filepath.Base(pb), "_reconfigure")
+}
+
+// supportedDBTypes lists the canonical db_types in the current allowlist, sorted.
+func supportedDBTypes() []string {
+	m := *playbookAllowlist.Load()
+	types := make([]string, 0, len(m))
+	for t := range m {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func formatPlaybooks(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+m[k])
+	}
+	return strings.Join(parts, ", ")
+}
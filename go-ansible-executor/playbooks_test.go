@@ -0,0 +1,176 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectPlaybook(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"postgresql.yml",
+		"postgresql_debian.yml",
+		"postgresql_uninstall.yml",
+		"postgresql_uninstall_debian.yml",
+		"mysql.yml",
+		"mysql_reconfigure.yml",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("- hosts: all\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	playbookAllowlist.Store(&map[string]string{
+		"postgresql": filepath.Join(dir, "postgresql.yml"),
+		"mysql":      filepath.Join(dir, "mysql.yml"),
+	})
+
+	tests := []struct {
+		name     string
+		dbType   string
+		osFamily string
+		action   string
+		want     string // file name in dir; "" for an error
+	}{
+		{name: "generic", dbType: "postgresql", want: "postgresql.yml"},
+		{name: "os-specific found", dbType: "postgresql", osFamily: "debian", want: "postgresql_debian.yml"},
+		{name: "os-specific missing falls back", dbType: "postgresql", osFamily: "rhel", want: "postgresql.yml"},
+		{name: "other engine falls back", dbType: "mysql", osFamily: "debian", want: "mysql.yml"},
+		{name: "uninstall", dbType: "postgresql", action: actionUninstall, want: "postgresql_uninstall.yml"},
+		{name: "os-specific uninstall", dbType: "postgresql", osFamily: "debian", action: actionUninstall, want: "postgresql_uninstall_debian.yml"},
+		{name: "uninstall falls back", dbType: "postgresql", osFamily: "suse", action: actionUninstall, want: "postgresql_uninstall.yml"},
+		{name: "no uninstall playbook", dbType: "mysql", action: actionUninstall},
+		{name: "reconfigure", dbType: "mysql", action: actionReconfigure, want: "mysql_reconfigure.yml"},
+		{name: "reconfigure uses the install playbook", dbType: "postgresql", action: actionReconfigure, want: "postgresql.yml"},
+		{name: "not allowlisted", dbType: "mariadb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectPlaybook(tt.dbType, tt.osFamily, tt.action)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("selectPlaybook() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("selectPlaybook() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadPlaybookAllowlistDir(t *testing.T) {
+	allowlist := filepath.Join(t.TempDir(), "allowlist.json")
+	if err := os.WriteFile(allowlist, []byte(`{"postgresql": "pg/site.yml", "mysql": "/srv/mysql.yml"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		file        string
+		playbookDir string
+		want        map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"postgresql": "playbooks/postgresql.yml", "mysql": "playbooks/mysql.yml", "mariadb": "playbooks/mariadb.yml"},
+		},
+		{
+			name:        "defaults under PLAYBOOK_DIR",
+			playbookDir: "/opt/playbooks",
+			want:        map[string]string{"postgresql": "/opt/playbooks/postgresql.yml", "mysql": "/opt/playbooks/mysql.yml", "mariadb": "/opt/playbooks/mariadb.yml"},
+		},
+		{
+			name: "file",
+			file: allowlist,
+			want: map[string]string{"postgresql": "pg/site.yml", "mysql": "/srv/mysql.yml"},
+		},
+		{
+			name:        "file under PLAYBOOK_DIR",
+			file:        allowlist,
+			playbookDir: "/opt/playbooks",
+			want:        map[string]string{"postgresql": "/opt/playbooks/pg/site.yml", "mysql": "/srv/mysql.yml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(d string) { cfg.PlaybookDir = d }(cfg.PlaybookDir)
+			cfg.PlaybookDir = tt.playbookDir
+			got, err := loadPlaybookAllowlist(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("loadPlaybookAllowlist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestPlaybook(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for _, f := range []string{"custom.yml", "sub/tuned.yaml", "notes.txt", "postgresql_uninstall.yml", "postgresql_reconfigure_debian.yml", "postgresql_verify.yml"} {
+		writePlaybook(t, filepath.Join(dir, f))
+	}
+	writePlaybook(t, filepath.Join(outside, "evil.yml"))
+	if err := os.Symlink(filepath.Join(outside, "evil.yml"), filepath.Join(dir, "link.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.yml"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d string) { cfg.PlaybookDir = d }(cfg.PlaybookDir)
+	cfg.PlaybookDir = dir
+
+	tests := []struct {
+		path    string
+		action  string
+		wantErr string // empty if it resolves to dir/path
+	}{
+		{path: "custom.yml"},
+		{path: "custom.yml", action: actionUninstall},
+		{path: "sub/tuned.yaml"},
+		{path: "postgresql_uninstall.yml", action: actionUninstall},
+		{path: "postgresql_uninstall.yml", wantErr: `a _uninstall playbook needs "action": "uninstall"`},
+		{path: "postgresql_uninstall.yml", action: actionReconfigure, wantErr: `needs "action": "uninstall"`},
+		{path: "postgresql_reconfigure_debian.yml", action: actionReconfigure},
+		{path: "postgresql_reconfigure_debian.yml", action: actionInstall, wantErr: `needs "action": "reconfigure"`},
+		{path: "postgresql_verify.yml", wantErr: "only runs as the verify step"},
+		{path: "missing.yml", wantErr: "not found"},
+		{path: "notes.txt", wantErr: "must be a .yml or .yaml file"},
+		{path: "../custom.yml", wantErr: "must be relative"},
+		{path: "sub/../custom.yml", wantErr: "must be relative"},
+		{path: "/etc/passwd.yml", wantErr: "must be relative"},
+		{path: "link.yml", wantErr: "must stay within the playbook dir"},
+		{path: "dir.yml", wantErr: "is not a file"},
+	}
+	for _, tt := range tests {
+		got, err := requestPlaybook(tt.path, tt.action)
+		if tt.wantErr == "" {
+			if err != nil || got != filepath.Join(dir, tt.path) {
+				t.Errorf("requestPlaybook(%q, %q) = %q, %v; want %q", tt.path, tt.action, got, err, filepath.Join(dir, tt.path))
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("requestPlaybook(%q, %q) = %v, want it to contain %q", tt.path, tt.action, err, tt.wantErr)
+		}
+	}
+}
+
+// writePlaybook creates a minimal playbook at path, with its directory.
+func writePlaybook(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("- hosts: all\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
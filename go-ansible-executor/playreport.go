@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The executor_results callback (playbooks/callback_plugins) appends every host
+// result as a JSON line to the file named by resultsFileEnv. It runs next to the
+// stdout callback, so ansible_output, journald and stream_output stay readable.
+const (
+	resultsCallback = "executor_results"
+	resultsFileEnv  = "EXECUTOR_RESULTS_FILE"
+)
+
+// maxFailedTasks bounds failed_tasks; a play against many hosts can fail a lot.
+const maxFailedTasks = 50
+
+// maxTaskOutputBytes bounds the captured output per matching task.
+const maxTaskOutputBytes = 4000
+
+// recapCounters are the per-host PLAY RECAP counters, in ansible's order.
+var recapCounters = []string{"ok", "changed", "unreachable", "failed", "skipped", "rescued", "ignored"}
+
+// FailedTask is one failed or unreachable task of a run.
+type FailedTask struct {
+	Task        string `json:"task"`
+	Host        string `json:"host"`
+	Msg         string `json:"msg,omitempty"`
+	Unreachable bool   `json:"unreachable,omitempty"`
+}
+
+// TaskOutput is the output of one task whose name matched TaskOutputFilter.
+type TaskOutput struct {
+	Task   string `json:"task"`
+	Output string `json:"output"`
+}
+
+// playReport is what a run's callback results say: every task's result per host and
+// the recap counters. It is parsed once per run (see playResult.Report).
+type playReport struct {
+	Tasks []taskReport              // in the order they ran
+	Stats map[string]map[string]int // recap counters by host; nil if the run never got that far
+}
+
+type taskReport struct {
+	Name  string
+	Hosts map[string]map[string]any // module result by host
+}
+
+// jsonEvent is one line of the executor_results callback: a task's result on a
+// host, or the recap counters at the end of the play.
+type jsonEvent struct {
+	Task struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"task"`
+	Hosts map[string]map[string]any `json:"hosts"`
+	Stats map[string]map[string]int `json:"stats"`
+}
+
+// parsePlayReport reads the event lines of the executor_results callback,
+// skipping any that don't parse, e.g. a line cut short by a killed run. It
+// returns nil if there are none, e.g. when ansible failed before the play.
+func parsePlayReport(output []byte) *playReport {
+	var (
+		rep   playReport
+		found bool
+		byID  = map[string]int{}
+	)
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		var ev jsonEvent
+		if !bytes.HasPrefix(line, []byte("{")) || json.Unmarshal(line, &ev) != nil {
+			continue
+		}
+		found = true
+		if ev.Task.Name != "" && len(ev.Hosts) > 0 {
+			i, ok := byID[ev.Task.ID]
+			if !ok || ev.Task.ID == "" {
+				i = len(rep.Tasks)
+				byID[ev.Task.ID] = i
+				rep.Tasks = append(rep.Tasks, taskReport{Name: ev.Task.Name, Hosts: map[string]map[string]any{}})
+			}
+			for host, res := range ev.Hosts {
+				rep.Tasks[i].Hosts[host] = res
+			}
+		}
+		if ev.Stats != nil {
+			rep.Stats = map[string]map[string]int{}
+			for host, counts := range ev.Stats {
+				rep.Stats[host] = counts
+				// the callback calls PLAY RECAP's failed= "failures"
+				if n, ok := counts["failures"]; ok {
+					delete(counts, "failures")
+					counts["failed"] = n
+				}
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &rep
+}
+
+// resultsCallbackEnv enables the executor_results callback, writing to path. The
+// worker's own ANSIBLE_CALLBACK_PLUGINS and ANSIBLE_CALLBACKS_ENABLED are kept.
+func resultsCallbackEnv(path string) []string {
+	dir := filepath.Join(cmp.Or(cfg.PlaybookDir, "playbooks"), "callback_plugins")
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	plugins, enabled := dir, resultsCallback
+	if v := os.Getenv("ANSIBLE_CALLBACK_PLUGINS"); v != "" {
+		plugins += string(os.PathListSeparator) + v
+	}
+	if v := os.Getenv("ANSIBLE_CALLBACKS_ENABLED"); v != "" {
+		enabled += "," + v
+	}
+	return []string{
+		resultsFileEnv + "=" + path,
+		"ANSIBLE_CALLBACK_PLUGINS=" + plugins,
+		"ANSIBLE_CALLBACKS_ENABLED=" + enabled,
+	}
+}
+
+// newResultsFile creates an empty private file for the callback to fill, next to
+// the run's inventory so that a sweep removes it if the worker dies mid-run.
+// removeResultsFile must be called when the run is over.
+func newResultsFile(id int, runID, dbType string) (string, error) {
+	path := filepath.Join(cfg.InventoryDir, fmt.Sprintf("vm_%d_%s_%s.results.jsonl", id, runID, dbType))
+	activeInventories.Store(path, struct{}{})
+	if err := withUmask(func() error { return writeNewFile(path, nil) }); err != nil {
+		activeInventories.Delete(path)
+		return "", fmt.Errorf("create results file: %w", err)
+	}
+	return path, nil
+}
+
+func removeResultsFile(path string) {
+	defer activeInventories.Delete(path)
+	if err := os.Remove(path); err != nil {
+		slog.Warn("remove results file failed", "path", path, "err", err)
+	}
+}
+
+// readPlayReport parses the callback's file, with secrets masked first. It returns
+// nil if the file is missing, empty or holds no JSON, e.g. when the callback
+// couldn't be loaded; callers then go by the text output.
+func readPlayReport(path string, secrets []string) *playReport {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("read results file failed", "path", path, "err", err)
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	rep := parsePlayReport(redactSecrets(data, secrets))
+	if rep == nil {
+		slog.Warn("results file holds no JSON results", "path", path)
+	}
+	return rep
+}
+
+// recap renders the counters as ansible's PLAY RECAP block; "" without them.
+func (p *playReport) recap() string {
+	if p == nil || p.Stats == nil {
+		return ""
+	}
+	lines := []string{"PLAY RECAP"}
+	for _, host := range sortedKeys(p.Stats) {
+		line := host + " :"
+		for _, k := range recapCounters {
+			line += fmt.Sprintf(" %s=%d", k, p.Stats[host][k])
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// failedTasks lists the failed and unreachable host results, at most maxFailedTasks.
+// Failures ignored with ignore_errors aren't listed. The results are masked before
+// parsing, so the messages hold no secrets.
+func (p *playReport) failedTasks() []FailedTask {
+	if p == nil {
+		return nil
+	}
+	var tasks []FailedTask
+	for _, t := range p.Tasks {
+		for _, host := range sortedKeys(t.Hosts) {
+			res := t.Hosts[host]
+			unreachable := res["unreachable"] == true
+			if res["failed"] != true && !unreachable || res["ignored"] == true {
+				continue
+			}
+			if len(tasks) == maxFailedTasks {
+				return tasks
+			}
+			tasks = append(tasks, FailedTask{
+				Task:        t.Name,
+				Host:        host,
+				Msg:         truncate(resultMessage(res), maxTaskOutputBytes),
+				Unreachable: unreachable,
+			})
+		}
+	}
+	return tasks
+}
+
+// taskOutputs returns the stdout/stderr (or msg) per host of every task whose name
+// contains filter (case-insensitive).
+func (p *playReport) taskOutputs(filter string) []TaskOutput {
+	if p == nil || filter == "" {
+		return nil
+	}
+	filter = strings.ToLower(filter)
+	var outs []TaskOutput
+	for _, t := range p.Tasks {
+		if !strings.Contains(strings.ToLower(t.Name), filter) {
+			continue
+		}
+		var b strings.Builder
+		for _, host := range sortedKeys(t.Hosts) {
+			res := t.Hosts[host]
+			fmt.Fprintf(&b, "%s: [%s]\n", resultState(res), host)
+			for _, k := range []string{"stdout", "stderr"} {
+				if s, _ := res[k].(string); s != "" {
+					b.WriteString(s + "\n")
+				}
+			}
+			if res["stdout"] == nil && res["stderr"] == nil {
+				if msg := resultMessage(res); msg != "" {
+					b.WriteString(msg + "\n")
+				}
+			}
+		}
+		outs = append(outs, TaskOutput{Task: t.Name, Output: truncate(strings.TrimSpace(b.String()), maxTaskOutputBytes)})
+	}
+	return outs
+}
+
+// noHosts reports a finished run whose play matched no hosts.
+func (p *playReport) noHosts() bool {
+	return p != nil && p.Stats != nil && len(p.Stats) == 0
+}
+
+// resultState names a host result like ansible's text output does.
+func resultState(res map[string]any) string {
+	switch {
+	case res["unreachable"] == true:
+		return "unreachable"
+	case res["failed"] == true:
+		return "failed"
+	case res["skipped"] == true:
+		return "skipped"
+	case res["changed"] == true:
+		return "changed"
+	}
+	return "ok"
+}
+
+// resultMessage is the most telling text of a module result: msg, else stderr,
+// else the skip or failure reason.
+func resultMessage(res map[string]any) string {
+	for _, k := range []string{"msg", "stderr", "reason"} {
+		switch v := res[k].(type) {
+		case nil:
+		case string:
+			if v != "" {
+				return v
+			}
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// resultsOutput is an executor_results file, ending in a line cut short.
+const resultsOutput = `{"_event": "v2_runner_on_ok", "task": {"id": "t1", "name": "Install packages"}, "hosts": {"10.0.0.1": {"changed": true, "msg": "installed"}}}
+{"_event": "v2_runner_on_ok", "task": {"id": "t2", "name": "Show version"}, "hosts": {"10.0.0.1": {"stdout": "postgres (PostgreSQL) 16.4", "stderr": ""}}}
+{"_event": "v2_runner_on_failed", "task": {"id": "t2", "name": "Show version"}, "hosts": {"10.0.0.2": {"failed": true, "stdout": "", "stderr": "psql: not found"}}}
+{"_event": "v2_runner_on_ok", "task": {"id": "t3", "name": "Print VERSION banner"}, "hosts": {"10.0.0.1": {"msg": "ready"}}}
+{"_event": "v2_playbook_on_stats", "stats": {"10.0.0.1": {"ok": 3, "changed": 1, "failures": 0}, "10.0.0.2": {"ok": 0, "failures": 1}}}
+{"_event": "v2_runner_on_ok", "task": {"id": "t4", "name": "Show vers
+`
+
+func TestTaskOutputs(t *testing.T) {
+	versionOutput := TaskOutput{
+		Task:   "Show version",
+		Output: "ok: [10.0.0.1]\npostgres (PostgreSQL) 16.4\nfailed: [10.0.0.2]\npsql: not found",
+	}
+	bannerOutput := TaskOutput{Task: "Print VERSION banner", Output: "ok: [10.0.0.1]\nready"}
+	tests := []struct {
+		name   string
+		filter string
+		want   []TaskOutput
+	}{
+		{name: "matching task", filter: "Show version", want: []TaskOutput{versionOutput}},
+		{name: "case-insensitive substring", filter: "version", want: []TaskOutput{versionOutput, bannerOutput}},
+		{name: "no matching task", filter: "Create database"},
+		{name: "no filter"},
+	}
+	rep := parsePlayReport([]byte(resultsOutput))
+	if rep == nil {
+		t.Fatal("no report")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rep.taskOutputs(tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("taskOutputs(%q) = %+v, want %+v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskOutputsBounded(t *testing.T) {
+	big := strings.Repeat("x", 3*maxTaskOutputBytes)
+	out := `{"task": {"id": "t1", "name": "Dump config"}, "hosts": {"10.0.0.1": {"stdout": "` + big + `"}}}` + "\n"
+	got := parsePlayReport([]byte(out)).taskOutputs("dump")
+	if len(got) != 1 {
+		t.Fatalf("taskOutputs() = %d tasks, want 1", len(got))
+	}
+	if n := len(got[0].Output); n > maxTaskOutputBytes+len("\n...[truncated]...") {
+		t.Errorf("output is %d bytes, want at most %d", n, maxTaskOutputBytes)
+	}
+	if !strings.HasSuffix(got[0].Output, "...[truncated]...") {
+		t.Errorf("output not marked as truncated")
+	}
+}
+
+func TestParsePlayReportWithoutJSON(t *testing.T) {
+	if rep := parsePlayReport([]byte(textRecapOutput)); rep != nil {
+		t.Errorf("parsePlayReport(text output) = %+v, want nil", rep)
+	}
+	if got := (*playReport)(nil).taskOutputs("version"); got != nil {
+		t.Errorf("taskOutputs() without a report = %+v, want nil", got)
+	}
+}
+
+func TestResultsFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		report     string
+		wantReport bool
+		wantFailed []FailedTask
+	}{
+		{
+			name: "callback results",
+			report: `{"_event": "v2_runner_on_failed", "task": {"id": "t1", "name": "Create role"}, "hosts": {"10.0.0.1": {"failed": true, "msg": "password db-secret rejected"}}}` + "\n" +
+				`{"_event": "v2_runner_on_failed", "task": {"id": "t2", "name": "Probe"}, "hosts": {"10.0.0.1": {"failed": true, "ignored": true, "msg": "ignored"}}}` + "\n" +
+				`{"_event": "v2_playbook_on_stats", "stats": {"10.0.0.1": {"ok": 3, "changed": 1, "failures": 1}}}` + "\n",
+			wantReport: true,
+			wantFailed: []FailedTask{{Task: "Create role", Host: "10.0.0.1", Msg: "password *** rejected"}},
+		},
+		{name: "callback not loaded"},
+		{name: "not json", report: "Traceback (most recent call last):\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			t.Setenv("ANSIBLE_CALLBACKS_ENABLED", "profile_tasks")
+			run := fakeRun{code: 2, output: textRecapOutput, report: tt.report}
+			runner := &fakeRunner{results: map[string]fakeRun{"10.0.0.1": run}}
+
+			req := testRequest()
+			invPath, err := writeInventory(req, "run1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer removeInventory(invPath)
+			ir := installRun{req: req, runID: "run1", invPath: invPath, publish: func(InstallStatus) {}, runner: runner}
+			res := ir.runDBType(context.Background(), req.DBType)
+
+			env := runner.lastEnv()
+			if v, _ := envValue(env, "ANSIBLE_CALLBACKS_ENABLED"); v != "executor_results,profile_tasks" {
+				t.Errorf("ANSIBLE_CALLBACKS_ENABLED = %q, want the worker's callbacks after executor_results", v)
+			}
+			if v, ok := envValue(env, "ANSIBLE_STDOUT_CALLBACK"); ok {
+				t.Errorf("ANSIBLE_STDOUT_CALLBACK = %q, want ansible's default", v)
+			}
+			path, _ := envValue(env, resultsFileEnv)
+			if want := filepath.Join(cfg.InventoryDir, "vm_7_run1_postgresql.results.jsonl"); path != want {
+				t.Errorf("results file = %q, want %q", path, want)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("results file %q left behind: %v", path, err)
+			}
+			if _, busy := activeInventories.Load(path); busy {
+				t.Errorf("results file %q still shielded from the sweep", path)
+			}
+
+			if (res.Report != nil) != tt.wantReport {
+				t.Fatalf("report = %+v, want one: %v", res.Report, tt.wantReport)
+			}
+			if got := res.failedTasks(); !slices.Equal(got, tt.wantFailed) {
+				t.Errorf("failed tasks = %+v, want %+v", got, tt.wantFailed)
+			}
+			recap, hosts := res.recap()
+			if !tt.wantReport && recap != extractRecap(textRecapOutput) {
+				t.Errorf("recap = %q, want the text output's", recap)
+			}
+			if len(hosts) == 0 {
+				t.Errorf("no recap counters")
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killGroupOnCancel leaves the default cancel, killing just the process, where
+// there are no process groups; WaitDelay still bounds the wait for its children.
+func killGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A killed run takes the processes it forked along, instead of waiting for them.
+func TestRunAnsibleKillsForks(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		cancel   bool
+		wantCode int
+	}{
+		{name: "timeout", timeout: 200 * time.Millisecond, wantCode: 124},
+		{name: "cancel request", timeout: time.Minute, cancel: true, wantCode: exitCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			if tt.cancel {
+				time.AfterFunc(200*time.Millisecond, func() { cancel(errCancelled) })
+			}
+			// a forked worker that outlives its parent with stdout still open
+			script := "sleep 30 & wait"
+			start := time.Now()
+			code, _, _ := runAnsible(ctx, "sh", []string{"-c", script}, nil, tt.timeout, "")
+			// the pipe only closes this soon if the fork was killed too
+			if elapsed := time.Since(start); elapsed > ansibleWaitDelay/2 {
+				t.Errorf("runAnsible() returned after %s, want it right after the kill", elapsed)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
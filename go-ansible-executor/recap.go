@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// extractRecap returns the final "PLAY RECAP" block (header plus host lines) from
+// ansible output, or "" if the run never got that far.
+func extractRecap(output string) string {
+	i := strings.LastIndex(output, "PLAY RECAP")
+	if i < 0 {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(output[i:], "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			// the block ends at the first blank line after the host lines
+			if len(lines) > 1 {
+				break
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseRecap parses the host lines of a PLAY RECAP block, e.g.
+// "10.0.0.1 : ok=12 changed=3 unreachable=0 failed=0", into per-host counters.
+func parseRecap(recap string) map[string]map[string]int {
+	hosts := map[string]map[string]int{}
+	for _, line := range strings.Split(recap, "\n") {
+		host, stats, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		counts := map[string]int{}
+		for _, field := range strings.Fields(stats) {
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if n, err := strconv.Atoi(v); err == nil {
+				counts[k] = n
+			}
+		}
+		hosts[strings.TrimSpace(host)] = counts
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	return hosts
+}
+
+// sumRecap adds up per-host counters into run totals.
+func sumRecap(hosts map[string]map[string]int) map[string]int {
+	if len(hosts) == 0 {
+		return nil
+	}
+	total := map[string]int{}
+	for _, counts := range hosts {
+		for k, n := range counts {
+			total[k] += n
+		}
+	}
+	return total
+}
+
+// addRecaps adds the per-host counters of hosts into total, creating it if needed.
+func addRecaps(total, hosts map[string]map[string]int) map[string]map[string]int {
+	for host, counts := range hosts {
+		if total == nil {
+			total = map[string]map[string]int{}
+		}
+		if total[host] == nil {
+			total[host] = map[string]int{}
+		}
+		for k, n := range counts {
+			total[host][k] += n
+		}
+	}
+	return total
+}
+
+// recapFingerprint hashes the per-host changed/failed counts of a recap. Repeated
+// runs of the same install should produce the same fingerprint; a different one
+// hints at a nondeterministic (flaky) playbook. Returns "" without a recap.
+func recapFingerprint(hosts map[string]map[string]int) string {
+	if len(hosts) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(hosts))
+	for h := range hosts {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+
+	sum := sha256.New()
+	for _, h := range names {
+		fmt.Fprintf(sum, "%s changed=%d failed=%d\n", h, hosts[h]["changed"], hosts[h]["failed"])
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
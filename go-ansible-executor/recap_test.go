@@ -0,0 +1,139 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+const textRecapOutput = `PLAY [postgresql] **************************************************************
+
+TASK [Install PostgreSQL] ******************************************************
+changed: [10.0.0.1]
+
+PLAY RECAP *********************************************************************
+10.0.0.1                   : ok=12   changed=3    unreachable=0    failed=0    skipped=2    rescued=0    ignored=0
+10.0.0.2                   : ok=4    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+
+`
+
+func TestExtractRecap(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "recap",
+			output: textRecapOutput,
+			want: "PLAY RECAP *********************************************************************\n" +
+				"10.0.0.1                   : ok=12   changed=3    unreachable=0    failed=0    skipped=2    rescued=0    ignored=0\n" +
+				"10.0.0.2                   : ok=4    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0",
+		},
+		{
+			name:   "last recap of several plays",
+			output: "PLAY RECAP\nold : ok=1 changed=0\n\nPLAY RECAP\nnew : ok=2 changed=1\n",
+			want:   "PLAY RECAP\nnew : ok=2 changed=1",
+		},
+		{
+			name:   "no recap",
+			output: "ERROR! the playbook: postgresql.yml could not be found\n",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractRecap(tt.output); got != tt.want {
+				t.Errorf("extractRecap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecapFingerprint(t *testing.T) {
+	base := parseRecap(extractRecap(textRecapOutput))
+	fp := recapFingerprint(base)
+	if len(fp) != 64 {
+		t.Fatalf("fingerprint = %q, want a sha256 in hex", fp)
+	}
+	tests := []struct {
+		name  string
+		hosts map[string]map[string]int
+		same  bool
+	}{
+		{
+			name:  "identical recap",
+			hosts: parseRecap(extractRecap(textRecapOutput)),
+			same:  true,
+		},
+		{
+			name: "other ok and skipped counts",
+			hosts: map[string]map[string]int{
+				"10.0.0.1": {"ok": 40, "changed": 3, "failed": 0, "skipped": 9},
+				"10.0.0.2": {"ok": 1, "changed": 0, "failed": 1},
+			},
+			same: true,
+		},
+		{
+			name: "one more change",
+			hosts: map[string]map[string]int{
+				"10.0.0.1": {"ok": 12, "changed": 4, "failed": 0},
+				"10.0.0.2": {"ok": 4, "changed": 0, "failed": 1},
+			},
+		},
+		{
+			name: "failure moved to another host",
+			hosts: map[string]map[string]int{
+				"10.0.0.1": {"ok": 12, "changed": 3, "failed": 1},
+				"10.0.0.2": {"ok": 4, "changed": 0, "failed": 0},
+			},
+		},
+		{
+			name:  "one host fewer",
+			hosts: map[string]map[string]int{"10.0.0.1": {"ok": 12, "changed": 3, "failed": 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recapFingerprint(tt.hosts); (got == fp) != tt.same {
+				t.Errorf("fingerprint %q vs %q, want same: %v", got, fp, tt.same)
+			}
+		})
+	}
+	if got := recapFingerprint(nil); got != "" {
+		t.Errorf("fingerprint without a recap = %q, want \"\"", got)
+	}
+}
+
+func TestApplyResultsRecapStats(t *testing.T) {
+	one := playResult{DBType: "postgresql", Output: []byte(textRecapOutput)}
+	other := playResult{DBType: "mysql", Output: []byte("PLAY RECAP\n10.0.0.1 : ok=2 changed=1 failed=0\n")}
+	tests := []struct {
+		name    string
+		results []playResult
+		want    map[string]int
+	}{
+		{
+			name:    "one db_type, summed over hosts",
+			results: []playResult{one},
+			want:    map[string]int{"ok": 16, "changed": 3, "unreachable": 0, "failed": 1, "skipped": 2, "rescued": 0, "ignored": 0},
+		},
+		{
+			name:    "summed over db_types",
+			results: []playResult{one, other},
+			want:    map[string]int{"ok": 18, "changed": 4, "unreachable": 0, "failed": 1, "skipped": 2, "rescued": 0, "ignored": 0},
+		},
+		{
+			name:    "no recap",
+			results: []playResult{{DBType: "postgresql", Output: []byte("ERROR! no playbook\n"), ExitCode: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var st InstallStatus
+			applyResults(&st, testRequest(), tt.results)
+			if !maps.Equal(st.RecapStats, tt.want) {
+				t.Errorf("recap_stats = %v, want %v", st.RecapStats, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// secretKeyRe matches result keys whose values must not be published as-is.
+var secretKeyRe = regexp.MustCompile(`(?i)pass|secret|token|key|credential`)
+
+// resultFilePath is where a run's playbook may write JSON results (extra var
+// result_file). It is named like an inventory so that the startup sweep of dir
+// removes it after a crash; until removeResultFile, the sweep leaves it alone.
+func resultFilePath(dir string, id int, runID string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create result dir: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("vm_%d_%s.result.json", id, runID))
+	activeInventories.Store(path, struct{}{})
+	return path, nil
+}
+
+// removeResultFile deletes a run's result file, if the playbook wrote one.
+func removeResultFile(path string) {
+	defer activeInventories.Delete(path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("remove result file failed", "path", path, "err", err)
+	}
+}
+
+// readResultFile parses the JSON a playbook left at path, with secret-looking keys
+// masked. A missing file (the playbook wrote nothing) returns nil, nil.
+func readResultFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read result file: %w", err)
+	}
+	var res map[string]any
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("parse result file %s: %w", path, err)
+	}
+	redactResult(res)
+	return res, nil
+}
+
+// redactResult masks, in place, every value under a secret-looking key at any depth.
+func redactResult(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if secretKeyRe.MatchString(k) {
+				v[k] = "***"
+				continue
+			}
+			redactResult(child)
+		}
+	case []any:
+		for _, child := range v {
+			redactResult(child)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resultWriter is a PlaybookRunner whose playbook writes data to its result_file.
+type resultWriter struct {
+	data     string
+	path     string // result_file of the last run
+	shielded bool   // whether the sweep skipped it during the run
+}
+
+func (r *resultWriter) Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (int, []byte, error) {
+	for i, a := range args[:len(args)-1] {
+		var vars map[string]string
+		if a == "--extra-vars" && json.Unmarshal([]byte(args[i+1]), &vars) == nil && vars["result_file"] != "" {
+			r.path = vars["result_file"]
+		}
+	}
+	_, r.shielded = activeInventories.Load(r.path)
+	if r.data != "" {
+		if err := os.WriteFile(r.path, []byte(r.data), 0o600); err != nil {
+			return 1, nil, err
+		}
+	}
+	return 0, nil, nil
+}
+
+func TestResultFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]any
+	}{
+		{
+			name: "written",
+			data: `{"engine": "postgresql", "admin": {"user": "postgres", "password": "generated"}}`,
+			want: map[string]any{"engine": "postgresql", "admin": map[string]any{"user": "postgres", "password": "***"}},
+		},
+		{name: "not written"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			cfg.ResultDir = filepath.Join(t.TempDir(), "results")
+			runner := &resultWriter{data: tt.data}
+			ir := installRun{req: testRequest(), runID: "run1", invPath: "inv.ini", publish: func(InstallStatus) {}, runner: runner}
+			res := ir.runDBType(context.Background(), "postgresql")
+
+			if dir, name := filepath.Split(runner.path); filepath.Clean(dir) != cfg.ResultDir || !strings.HasPrefix(name, "vm_7_") {
+				t.Errorf("result_file = %q, want a vm_7_* file in %s for the sweep", runner.path, cfg.ResultDir)
+			}
+			if !runner.shielded {
+				t.Error("result file not shielded from the sweep during the run")
+			}
+			if _, err := os.Stat(runner.path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("result file left behind: %v", err)
+			}
+			if _, busy := activeInventories.Load(runner.path); busy {
+				t.Error("result file still shielded from the sweep after the run")
+			}
+			got, _ := json.Marshal(res.ResultData)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("result_data = %s, want %s", got, want)
+			}
+		})
+	}
+}
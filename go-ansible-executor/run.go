@@ -0,0 +1,461 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// playResult is the outcome of running the playbook for one requested db_type.
+type playResult struct {
+	DBType     string // canonical
+	Playbook   string
+	Args       []string
+	ExitCode   int
+	Output     []byte
+	Err        error
+	ResultData map[string]any
+	Category   string // set when the run failed before ansible started
+	Started    time.Time
+	Duration   time.Duration // zero Started: ansible never ran
+	Attempts   int           // playbook runs, > 1 after transient failures (MAX_RETRIES)
+	Report     *playReport   // the executor_results callback's; nil if it wrote none
+}
+
+// TypeResult reports one db_type's run in a multi-type request.
+type TypeResult struct {
+	DBType          string         `json:"db_type"`
+	Playbook        string         `json:"playbook,omitempty"`
+	Status          string         `json:"status"`
+	AnsibleExitCode int            `json:"ansible_exit_code"`
+	ExitReason      string         `json:"exit_reason,omitempty"`
+	Recap           string         `json:"recap,omitempty"`
+	RecapStats      map[string]int `json:"recap_stats,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	ErrorCode       string         `json:"error_code,omitempty"`
+	Category        string         `json:"category,omitempty"`
+	SSHDiagnostic   *SSHDiagnostic `json:"ssh_diagnostic,omitempty"`
+	StartedAt       *time.Time     `json:"started_at,omitempty"`
+	DurationMs      int64          `json:"duration_ms,omitempty"`
+	Attempts        int            `json:"attempts,omitempty"`
+	FailedTasks     []FailedTask   `json:"failed_tasks,omitempty"`
+}
+
+// installRun carries the per-message state shared by each db_type run.
+type installRun struct {
+	req      InstallRequest
+	runID    string
+	invPath  string
+	priority int
+	publish  func(InstallStatus)
+	runner   PlaybookRunner
+	nc       *nats.Conn // for stream_output
+}
+
+// runDBType selects, builds and runs the playbook for one requested db_type
+// (already validated) against the written inventory.
+func (ir *installRun) runDBType(parent context.Context, rawType string) (res playResult) {
+	req, invPath, runID, priority := ir.req, ir.invPath, ir.runID, ir.priority
+	dbType, version, _ := normalizeDBType(rawType)
+	version = cmp.Or(version, req.DBVersion)
+	res = playResult{DBType: dbType}
+
+	parent, span := startSpan(parent, "runPlaybook")
+	span.SetAttr("db_type", dbType)
+	defer func() { span.endPlay(res) }()
+
+	// Choose a playbook based on the canonical db_type, unless the request names one
+	var playbookPath string
+	var err error
+	if req.PlaybookPath != "" {
+		playbookPath, err = requestPlaybook(req.PlaybookPath, req.Action)
+	} else {
+		playbookPath, err = selectPlaybook(dbType, req.OSFamily, req.Action)
+	}
+	if err != nil {
+		res.Err, res.Category = err, catClient
+		return res
+	}
+	res.Playbook = playbookPath
+	span.SetAttr("playbook", playbookPath)
+
+	// Build the command line
+	args := playbookArgs(invPath, playbookPath, req)
+	// the request's tuning vars (validated to not clash with the worker's own)
+	extraVars := maps.Clone(req.ExtraVars)
+	if extraVars == nil {
+		extraVars = map[string]string{}
+	}
+	// encrypted ones come from the vault vars file next to the inventory instead
+	maps.DeleteFunc(extraVars, func(_, v string) bool { return isVaultValue(v) })
+	if f := req.vaultVarsFileFor(invPath); f != "" {
+		args = append(args, "--extra-vars", "@"+f)
+	}
+	if version != "" {
+		// from db_version or a suffixed db_type, e.g. "postgresql15" => db_version=15
+		extraVars["db_version"] = version
+	}
+	if req.Serial != "" {
+		extraVars["serial"] = string(req.Serial)
+	}
+
+	// Optional results file the playbook can fill in; removed on every path
+	resultPath := ""
+	if cfg.ResultDir != "" {
+		p, err := resultFilePath(cfg.ResultDir, req.ID, runID+"_"+dbType)
+		if err != nil {
+			res.Err, res.Category = err, catInternal
+			return res
+		}
+		resultPath = p
+		defer removeResultFile(resultPath)
+		extraVars["result_file"] = resultPath
+	}
+	if len(extraVars) > 0 {
+		ev, _ := json.Marshal(extraVars)
+		args = append(args, "--extra-vars", string(ev))
+	}
+	res.Args = args
+
+	env := req.hostKeyEnv()
+	if req.Strategy != "" {
+		env = append(env, "ANSIBLE_STRATEGY="+req.Strategy)
+	}
+
+	// Optional cheap check for authoring mistakes, before any connection to the host
+	if cfg.SyntaxCheck && !ir.syntaxCheck(parent, &res, env) {
+		return res
+	}
+
+	// Structured results, next to the human-readable stdout; optional
+	resultsFile, err := newResultsFile(req.ID, runID, dbType)
+	if err != nil {
+		slog.Warn("no structured results for this run", "id", req.ID, "err", err)
+	} else {
+		defer removeResultsFile(resultsFile)
+		env = append(env, resultsCallbackEnv(resultsFile)...)
+	}
+
+	// Wait for a run slot, then run ansible playbook
+	if err := runSlots.acquire(parent, priority); err != nil {
+		res.Err = fmt.Errorf("cancelled while waiting for a run slot: %w", context.Cause(parent))
+		res.Category = catInternal
+		return res
+	}
+	estimate := etas.estimate(dbType, cfg.ETADefault)
+	ir.publish(InstallStatus{
+		ID:                  req.ID,
+		Name:                req.Name,
+		Stage:               stageRunning,
+		Status:              stageRunning,
+		DBType:              dbType,
+		Inventory:           invPath,
+		Playbook:            playbookPath,
+		Priority:            priority,
+		Strategy:            req.Strategy,
+		Serial:              req.Serial,
+		EstimatedDurationMs: estimate.Milliseconds(),
+		Timestamp:           time.Now(),
+	})
+	started := time.Now()
+	stopHeartbeat := ir.heartbeat(dbType, playbookPath, started)
+	runCtx := parent
+	var stream *outputStream
+	if req.StreamOutput {
+		stream = newOutputStream(ir.nc, req, runID, dbType)
+		runCtx = withOutputTap(parent, stream)
+	}
+	holding := true
+retries:
+	for attempt := 1; ; attempt++ {
+		res.Attempts = attempt
+		if resultsFile != "" {
+			os.Truncate(resultsFile, 0) // report the last attempt's results only
+		}
+		metrics.running.Add(1)
+		if stream != nil {
+			stream.startAttempt(attempt)
+		}
+		res.ExitCode, res.Output, res.Err = ir.runner.Run(runCtx, playbookPath, args, env, req.playTimeout(), outputPrefix(req.ID))
+		if stream != nil {
+			stream.end()
+		}
+		metrics.running.Add(-1)
+		res.Output = redactSecrets(res.Output, req.secrets())
+		if attempt > cfg.MaxRetries || !res.transient() {
+			break
+		}
+		backoff := maxRetryBackoff
+		if attempt < 16 {
+			backoff = min(cfg.RetryBackoff<<(attempt-1), maxRetryBackoff)
+		}
+		slog.Warn("transient playbook failure, retrying", "id", req.ID, "db_type", dbType, "attempt", attempt,
+			"exit_code", res.ExitCode, "category", res.category(), "backoff", backoff)
+		// don't hold a slot other requests could use while sleeping; queue again at
+		// the same priority afterwards
+		runSlots.release()
+		select {
+		case <-time.After(backoff):
+		case <-parent.Done():
+			res.Err = fmt.Errorf("cancelled while waiting to retry: %w", context.Cause(parent))
+			holding = false
+			break retries
+		}
+		if err := runSlots.acquire(parent, priority); err != nil {
+			res.Err = fmt.Errorf("cancelled while waiting to retry: %w", context.Cause(parent))
+			holding = false
+			break retries
+		}
+	}
+	elapsed := time.Since(started)
+	res.Started, res.Duration = started, elapsed
+	stopHeartbeat()
+	if holding {
+		runSlots.release()
+	}
+	if resultsFile != "" {
+		// without it, the text output and its recap still tell the story
+		res.Report = readPlayReport(resultsFile, req.secrets())
+	}
+
+	// check runs, teardowns and reconfigures do different work, so they'd skew the estimate
+	if status, _, _ := res.outcome(); status == "success" && res.Attempts == 1 && !req.CheckMode && cmp.Or(req.Action, actionInstall) == actionInstall {
+		etas.observe(dbType, elapsed)
+	}
+
+	if resultPath != "" {
+		if res.ResultData, err = readResultFile(resultPath); err != nil {
+			slog.Warn("read result file failed", "id", req.ID, "err", err)
+		}
+	}
+	return res
+}
+
+// heartbeat publishes a heartbeat status for dbType every heartbeatInterval until
+// the returned stop is called. stop waits for an in-progress publish, so no
+// heartbeat can follow the final status.
+func (ir *installRun) heartbeat(dbType, playbook string, started time.Time) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		tick := time.NewTicker(heartbeatInterval)
+		defer tick.Stop()
+		for seq := 1; ; seq++ {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+			ir.publish(InstallStatus{
+				ID:        ir.req.ID,
+				Name:      ir.req.Name,
+				Stage:     stageHeartbeat,
+				Status:    stageRunning,
+				DBType:    dbType,
+				Inventory: ir.invPath,
+				Playbook:  playbook,
+				Priority:  ir.priority,
+				ElapsedMs: time.Since(started).Milliseconds(),
+				Seq:       seq,
+				Timestamp: time.Now(),
+			})
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// outcome maps a run to its status, error message and error code.
+func (r playResult) outcome() (status, errMsg, errCode string) {
+	if r.Err != nil || r.ExitCode != 0 {
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		if errors.Is(r.Err, errCancelled) {
+			return "error", errMsg, ""
+		}
+		if errors.Is(r.Err, errShutdown) || errors.Is(r.Err, context.Canceled) {
+			return "error", errMsg, errCodeShutdown
+		}
+		if errors.Is(r.Err, errPlaybookInvalid) {
+			return "error", errMsg, errCodePlaybookInvalid
+		}
+		return "error", errMsg, ""
+	}
+	if cfg.StrictNoHosts && (r.Report.noHosts() || noHostsMatched(r.Output)) {
+		// ansible only warns and exits 0 here, which would look like a success
+		return "error", "no hosts matched", errCodeNoHosts
+	}
+	return "success", "", ""
+}
+
+// recap is the run's PLAY RECAP block and its counters by host: from the JSON
+// report, else scraped from the text output.
+func (r playResult) recap() (string, map[string]map[string]int) {
+	if r.Report != nil && r.Report.Stats != nil {
+		return r.Report.recap(), r.Report.Stats
+	}
+	recap := extractRecap(string(r.Output))
+	return recap, parseRecap(recap)
+}
+
+// failedTasks lists the failed tasks of a failed run; a successful one only
+// failed ignored ones.
+func (r playResult) failedTasks() []FailedTask {
+	if status, _, _ := r.outcome(); status != "error" {
+		return nil
+	}
+	return r.Report.failedTasks()
+}
+
+// sshDiagnostic classifies an SSH failure in a failed run's output; nil otherwise.
+func (r playResult) sshDiagnostic() *SSHDiagnostic {
+	if status, _, _ := r.outcome(); status != "error" {
+		return nil
+	}
+	return diagnoseSSH(r.Output)
+}
+
+func (r playResult) commandLine() string {
+	if len(r.Args) == 0 {
+		return ""
+	}
+	return "ansible-playbook " + strings.Join(r.Args, " ")
+}
+
+// applyResults fills the run-dependent fields of st. A single db_type reports its
+// run directly; several are combined, with per-type details in st.Results.
+func applyResults(st *InstallStatus, req InstallRequest, results []playResult) {
+	if len(results) == 1 {
+		r := results[0]
+		recap, hosts := r.recap()
+		st.Status, st.Error, st.ErrorCode = r.outcome()
+		st.AnsibleExitCode = r.ExitCode
+		st.ExitReason = exitReason(r.ExitCode, r.Output)
+		st.Playbook = r.Playbook
+		st.CommandLine = r.commandLine()
+		st.AnsibleOutput = truncate(combinedOutput(results), cfg.MaxOutputBytes)
+		st.Recap = recap
+		st.RecapStats = sumRecap(hosts)
+		if len(req.Hosts) > 0 {
+			st.HostResults = hosts
+		}
+		st.Fingerprint = recapFingerprint(hosts)
+		st.TaskOutputs = r.Report.taskOutputs(req.TaskOutputFilter)
+		st.ResultData = r.ResultData
+		st.SSHDiagnostic = r.sshDiagnostic()
+		st.Category = r.category()
+		st.StartedAt, st.DurationMs = runSpan(results)
+		st.Attempts = r.Attempts
+		st.FailedTasks = r.failedTasks()
+		return
+	}
+
+	st.Status = "success"
+	st.Playbook = results[0].Playbook
+	var commands []string
+	for _, r := range results {
+		status, errMsg, errCode := r.outcome()
+		recap, hosts := r.recap()
+		stats := sumRecap(hosts)
+		if len(req.Hosts) > 0 {
+			st.HostResults = addRecaps(st.HostResults, hosts)
+		}
+		for k, n := range stats {
+			if st.RecapStats == nil {
+				st.RecapStats = map[string]int{}
+			}
+			st.RecapStats[k] += n
+		}
+		startedAt, durationMs := runSpan([]playResult{r})
+		st.Results = append(st.Results, TypeResult{
+			DBType:          r.DBType,
+			Playbook:        r.Playbook,
+			Status:          status,
+			AnsibleExitCode: r.ExitCode,
+			ExitReason:      exitReason(r.ExitCode, r.Output),
+			Recap:           recap,
+			RecapStats:      stats,
+			Error:           errMsg,
+			ErrorCode:       errCode,
+			SSHDiagnostic:   r.sshDiagnostic(),
+			Category:        r.category(),
+			StartedAt:       startedAt,
+			DurationMs:      durationMs,
+			Attempts:        r.Attempts,
+			FailedTasks:     r.failedTasks(),
+		})
+		st.Attempts += r.Attempts
+		st.FailedTasks = append(st.FailedTasks, st.Results[len(st.Results)-1].FailedTasks...)
+		// the first failing type determines the overall error
+		if status == "error" && st.Status == "success" {
+			st.Status = "error"
+			st.AnsibleExitCode = r.ExitCode
+			st.ExitReason = st.Results[len(st.Results)-1].ExitReason
+			st.Playbook = r.Playbook
+			st.Error = fmt.Sprintf("%s: %s", r.DBType, errMsg)
+			st.ErrorCode = errCode
+			st.SSHDiagnostic = st.Results[len(st.Results)-1].SSHDiagnostic
+			st.Category = st.Results[len(st.Results)-1].Category
+		}
+		if cl := r.commandLine(); cl != "" {
+			commands = append(commands, cl)
+		}
+		st.TaskOutputs = append(st.TaskOutputs, r.Report.taskOutputs(req.TaskOutputFilter)...)
+		if r.ResultData != nil {
+			if st.ResultData == nil {
+				st.ResultData = map[string]any{}
+			}
+			st.ResultData[r.DBType] = r.ResultData
+		}
+	}
+	st.CommandLine = strings.Join(commands, " && ")
+	st.AnsibleOutput = truncate(combinedOutput(results), cfg.MaxOutputBytes)
+	st.StartedAt, st.DurationMs = runSpan(results)
+}
+
+// combinedOutput is the full ansible output of results: a single db_type's as is,
+// several one after another under a "===== <db_type> =====" header.
+func combinedOutput(results []playResult) string {
+	if len(results) == 1 {
+		return string(results[0].Output)
+	}
+	outputs := make([]string, 0, len(results))
+	for _, r := range results {
+		outputs = append(outputs, fmt.Sprintf("===== %s =====\n%s", r.DBType, r.Output))
+	}
+	return strings.Join(outputs, "\n")
+}
+
+// runSpan is when the first playbook of results started and how long it took
+// until the last one returned; nil, 0 if none ran.
+func runSpan(results []playResult) (*time.Time, int64) {
+	var first, last time.Time
+	for _, r := range results {
+		if r.Started.IsZero() {
+			continue
+		}
+		if first.IsZero() || r.Started.Before(first) {
+			first = r.Started
+		}
+		if end := r.Started.Add(r.Duration); end.After(last) {
+			last = end
+		}
+	}
+	if first.IsZero() {
+		return nil, 0
+	}
+	return &first, last.Sub(first).Milliseconds()
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const noHostsOutput = `[WARNING]: Could not match supplied host pattern, ignoring: db
+PLAY [postgresql] **************************************************************
+skipping: no hosts matched
+
+PLAY RECAP *********************************************************************
+
+`
+
+func TestOutcomeNoHosts(t *testing.T) {
+	tests := []struct {
+		name       string
+		strict     bool
+		output     string
+		report     string
+		exitCode   int
+		wantStatus string
+		wantCode   string
+	}{
+		{name: "text, strict", strict: true, output: noHostsOutput, wantStatus: "error", wantCode: errCodeNoHosts},
+		{name: "capitalized, strict", strict: true, output: "[WARNING]: No hosts matched, nothing to do\n", wantStatus: "error", wantCode: errCodeNoHosts},
+		{name: "text, default", output: noHostsOutput, wantStatus: "success"},
+		{name: "json, strict", strict: true, report: `{"_event": "v2_playbook_on_stats", "stats": {}}` + "\n", wantStatus: "error", wantCode: errCodeNoHosts},
+		{name: "hosts matched, strict", strict: true, output: textRecapOutput, wantStatus: "success"},
+		{name: "failed run, strict", strict: true, output: noHostsOutput, exitCode: 2, wantStatus: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(s bool) { cfg.StrictNoHosts = s }(cfg.StrictNoHosts)
+			cfg.StrictNoHosts = tt.strict
+			r := playResult{ExitCode: tt.exitCode, Output: []byte(tt.output), Report: parsePlayReport([]byte(tt.report))}
+			status, _, code := r.outcome()
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("outcome() = %q, %q, want %q, %q", status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestOutcomeShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{name: "killed at shutdown", err: fmt.Errorf("ansible-playbook killed: %w", errShutdown), wantCode: errCodeShutdown},
+		{name: "cancelled waiting for a slot", err: fmt.Errorf("cancelled while waiting for a run slot: %w", context.Canceled), wantCode: errCodeShutdown},
+		{name: "cancelled by request", err: fmt.Errorf("ansible-playbook killed: %w", errCancelled)},
+		{name: "task failed", err: errors.New("exit status 2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, code := playResult{ExitCode: 1, Err: tt.err}.outcome()
+			if status != "error" || code != tt.wantCode {
+				t.Errorf("outcome() = %q, code %q, want error, code %q", status, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRunSpan(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		results []playResult
+		wantAt  time.Time // zero: nil
+		wantMs  int64
+	}{
+		{name: "none ran", results: []playResult{{Err: errCancelled}}},
+		{name: "one", results: []playResult{{Started: t0, Duration: 90 * time.Second}}, wantAt: t0, wantMs: 90000},
+		{
+			name: "sequential, one never ran",
+			results: []playResult{
+				{Started: t0, Duration: time.Minute},
+				{Started: t0.Add(time.Minute), Duration: 30 * time.Second},
+				{},
+			},
+			wantAt: t0, wantMs: 90000,
+		},
+		{
+			name: "parallel",
+			results: []playResult{
+				{Started: t0.Add(time.Second), Duration: 2 * time.Minute},
+				{Started: t0, Duration: time.Minute},
+			},
+			wantAt: t0, wantMs: 121000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, ms := runSpan(tt.results)
+			if (at == nil) != tt.wantAt.IsZero() || (at != nil && !at.Equal(tt.wantAt)) || ms != tt.wantMs {
+				t.Errorf("runSpan() = %v, %d; want %v, %d", at, ms, tt.wantAt, tt.wantMs)
+			}
+		})
+	}
+}
+
+func TestRunDBTypeRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		exitCodes    []int  // of the successive runs; 0 once they run out
+		output       string // printed by every run
+		maxRetries   int
+		wantAttempts int
+		wantExit     int
+	}{
+		{name: "no retries", exitCodes: []int{ansibleExitUnreachable}, wantAttempts: 1, wantExit: ansibleExitUnreachable},
+		{name: "unreachable once", exitCodes: []int{ansibleExitUnreachable}, maxRetries: 2, wantAttempts: 2},
+		{name: "retries exhausted", exitCodes: []int{ansibleExitUnreachable, ansibleExitUnreachable, ansibleExitUnreachable}, maxRetries: 2, wantAttempts: 3, wantExit: ansibleExitUnreachable},
+		{name: "task failure", exitCodes: []int{2}, maxRetries: 2, wantAttempts: 1, wantExit: 2},
+		{
+			name:         "task failure that timed out",
+			exitCodes:    []int{2},
+			output:       `fatal: [10.0.0.1]: FAILED! => {"changed": false, "msg": "Request failed: <urlopen error [Errno 110] Connection timed out>"}`,
+			maxRetries:   2,
+			wantAttempts: 1,
+			wantExit:     2,
+		},
+		{
+			name:         "login rejected",
+			exitCodes:    []int{ansibleExitUnreachable},
+			output:       `fatal: [10.0.0.1]: UNREACHABLE! => {"msg": "Failed to connect to the host via ssh: admin@10.0.0.1: Permission denied (publickey).", "unreachable": true}`,
+			maxRetries:   2,
+			wantAttempts: 1,
+			wantExit:     ansibleExitUnreachable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			// an ansible-playbook that exits with the next code on each run
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "out"), []byte(tt.output+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			script := "#!/bin/sh\ncat " + dir + "/out\nn=$(cat " + dir + "/n 2>/dev/null || echo 0)\necho $((n+1)) > " + dir + "/n\ncase $n in\n"
+			for i, code := range tt.exitCodes {
+				script += fmt.Sprintf("%d) exit %d;;\n", i, code)
+			}
+			script += "esac\n"
+			if err := os.WriteFile(filepath.Join(dir, "ansible-playbook"), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			defer func(c Config) { cfg = c }(cfg)
+			cfg.MaxRetries, cfg.RetryBackoff = tt.maxRetries, time.Millisecond
+
+			ir := installRun{req: testRequest(), runID: "run1", invPath: "inv.ini", publish: func(InstallStatus) {}, runner: execRunner{}}
+			res := ir.runDBType(context.Background(), "postgresql")
+			if res.Attempts != tt.wantAttempts || res.ExitCode != tt.wantExit {
+				t.Errorf("attempts = %d, exit %d; want %d, exit %d", res.Attempts, res.ExitCode, tt.wantAttempts, tt.wantExit)
+			}
+		})
+	}
+}
+
+func TestRunDBTypeVersion(t *testing.T) {
+	tests := []struct {
+		dbType    string
+		dbVersion string
+		want      string // db_version extra var; empty for none
+	}{
+		{dbType: "postgresql"},
+		{dbType: "postgresql", dbVersion: "15", want: "15"},
+		{dbType: "pg16", want: "16"},
+		{dbType: "pg16", dbVersion: "16", want: "16"},
+	}
+	for _, tt := range tests {
+		setupWorker(t)
+		req := testRequest()
+		req.DBType, req.DBVersion = tt.dbType, tt.dbVersion
+		ir := installRun{req: req, runID: "run1", invPath: "inv.ini", publish: func(InstallStatus) {}, runner: playbookResults{}}
+		res := ir.runDBType(context.Background(), tt.dbType)
+
+		var got string
+		if i := slices.Index(res.Args, "--extra-vars"); i >= 0 {
+			var vars map[string]string
+			if err := json.Unmarshal([]byte(res.Args[i+1]), &vars); err != nil {
+				t.Fatal(err)
+			}
+			got = vars["db_version"]
+		}
+		if got != tt.want {
+			t.Errorf("%s with db_version %q: db_version = %q, want %q", tt.dbType, tt.dbVersion, got, tt.want)
+		}
+	}
+}
+
+func TestApplyResultsPlaybook(t *testing.T) {
+	pg := playResult{DBType: "postgresql", Playbook: "playbooks/postgresql.yml"}
+	my := playResult{DBType: "mysql", Playbook: "playbooks/mysql.yml"}
+	failed := my
+	failed.ExitCode = 2
+	tests := []struct {
+		name    string
+		results []playResult
+		want    string
+	}{
+		{name: "single", results: []playResult{pg}, want: pg.Playbook},
+		{name: "all succeed", results: []playResult{pg, my}, want: pg.Playbook},
+		{name: "second fails", results: []playResult{pg, failed}, want: my.Playbook},
+		{name: "none selected", results: []playResult{{DBType: "postgresql", Err: errors.New("no playbook")}}},
+	}
+	for _, tt := range tests {
+		var st InstallStatus
+		applyResults(&st, testRequest(), tt.results)
+		if st.Playbook != tt.want {
+			t.Errorf("%s: playbook = %q, want %q", tt.name, st.Playbook, tt.want)
+		}
+	}
+}
+
+// exitSequence is a PlaybookRunner that exits with the next code on each run
+// (0 once they run out) and signals every start on started.
+type exitSequence struct {
+	codes   []int
+	started chan struct{}
+}
+
+func (r *exitSequence) Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (int, []byte, error) {
+	r.started <- struct{}{}
+	if len(r.codes) == 0 {
+		return 0, nil, nil
+	}
+	code := r.codes[0]
+	r.codes = r.codes[1:]
+	return code, nil, nil
+}
+
+// A run waiting to retry leaves its slot to others.
+func TestRunDBTypeRetryFreesSlot(t *testing.T) {
+	setupWorker(t)
+	defer func(c Config) { cfg = c }(cfg)
+	cfg.MaxRetries, cfg.RetryBackoff = 1, 500*time.Millisecond
+	runSlots = newAdmission(1)
+
+	runner := &exitSequence{codes: []int{ansibleExitUnreachable}, started: make(chan struct{}, 2)}
+	ir := installRun{req: testRequest(), runID: "run1", invPath: "inv.ini", publish: func(InstallStatus) {}, runner: runner}
+	done := make(chan playResult)
+	go func() { done <- ir.runDBType(context.Background(), "postgresql") }()
+
+	<-runner.started
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := runSlots.acquire(ctx, 0); err != nil {
+		t.Fatal("slot still held during the retry backoff")
+	}
+	runSlots.release()
+
+	if res := <-done; res.Attempts != 2 || res.ExitCode != 0 {
+		t.Errorf("attempts = %d, exit %d; want 2, exit 0", res.Attempts, res.ExitCode)
+	}
+	if err := runSlots.acquire(ctx, 0); err != nil {
+		t.Fatal("slot not released after the run")
+	}
+	runSlots.release()
+}
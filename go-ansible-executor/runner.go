@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// PlaybookRunner runs a playbook with ansible-playbook args and extra env entries,
+// returning its exit code and combined output. handleMessage takes one, so the
+// message handling can be driven without a real ansible.
+type PlaybookRunner interface {
+	Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (exitCode int, output []byte, err error)
+}
+
+// execRunner is the PlaybookRunner used by the worker: it execs ANSIBLE_PLAYBOOK_BIN.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (int, []byte, error) {
+	return runPlaybook(ctx, playbookPath, args, env, timeout, logPrefix)
+}
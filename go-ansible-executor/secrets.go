@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// secrets lists the credential values from the request that must never be published,
+// longest first so a value containing another is masked as a whole.
+func (r InstallRequest) secrets() []string {
+	var out []string
+	for _, s := range []string{r.VMPassword, r.BecomePassword, r.DBPassword, r.VaultPassword} {
+		if s != "" {
+			out = append(out, s)
+			// verbose output dumps vars as JSON, where quotes and backslashes are escaped
+			if esc := jsonEscaped(s); esc != s {
+				out = append(out, esc)
+			}
+		}
+	}
+	if key := strings.TrimSpace(r.SSHPrivateKey); key != "" {
+		out = append(out, key)
+		// tasks may print the key a line at a time
+		for _, line := range strings.Split(key, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "-----") {
+				out = append(out, line)
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i]) > len(out[j]) })
+	return out
+}
+
+// jsonEscaped is s as it appears inside a JSON string.
+func jsonEscaped(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // python's json leaves <, > and & alone
+	_ = enc.Encode(s)
+	b := bytes.TrimSpace(buf.Bytes())
+	return string(b[1 : len(b)-1])
+}
+
+// redactSecrets replaces every occurrence of each secret in output with "***".
+func redactSecrets(output []byte, secrets []string) []byte {
+	for _, s := range secrets {
+		output = bytes.ReplaceAll(output, []byte(s), []byte("***"))
+	}
+	return output
+}
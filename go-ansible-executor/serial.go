@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Serial is ansible's play-level batch size: a host count ("2") or a percentage
+// of the play's hosts ("25%"). In JSON it may be a number or a string.
+type Serial string
+
+func (s *Serial) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = Serial(n.String())
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("serial must be a number or a string like \"25%%\"")
+	}
+	*s = Serial(strings.TrimSpace(str))
+	return nil
+}
+
+// validate accepts a positive integer or a percentage in 1..100.
+func (s Serial) validate() error {
+	v := string(s)
+	if pct, ok := strings.CutSuffix(v, "%"); ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 1 || n > 100 {
+			return fmt.Errorf("invalid serial %q (percentage must be 1%%..100%%)", v)
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid serial %q (want a positive host count or a percentage)", v)
+	}
+	return nil
+}
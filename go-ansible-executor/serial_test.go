@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSerial(t *testing.T) {
+	tests := []struct {
+		json      string
+		want      Serial
+		wantValid bool
+	}{
+		{json: `2`, want: "2", wantValid: true},
+		{json: `"2"`, want: "2", wantValid: true},
+		{json: `"25%"`, want: "25%", wantValid: true},
+		{json: `" 100% "`, want: "100%", wantValid: true},
+		{json: `"1%"`, want: "1%", wantValid: true},
+		{json: `0`, want: "0"},
+		{json: `-1`, want: "-1"},
+		{json: `2.5`, want: "2.5"},
+		{json: `"0%"`, want: "0%"},
+		{json: `"101%"`, want: "101%"},
+		{json: `"%"`, want: "%"},
+		{json: `"two"`, want: "two"},
+		{json: `"25 %"`, want: "25 %"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var s Serial
+			if err := json.Unmarshal([]byte(tt.json), &s); err != nil {
+				t.Fatal(err)
+			}
+			if s != tt.want {
+				t.Errorf("serial = %q, want %q", s, tt.want)
+			}
+			if err := s.validate(); (err == nil) != tt.wantValid {
+				t.Errorf("validate() = %v, want valid: %v", err, tt.wantValid)
+			}
+		})
+	}
+
+	for _, bad := range []string{`true`, `[2]`, `{"n": 2}`} {
+		var s Serial
+		if err := json.Unmarshal([]byte(bad), &s); err == nil {
+			t.Errorf("unmarshal %s = %q, want an error", bad, s)
+		}
+	}
+}
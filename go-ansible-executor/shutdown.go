@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// inflight counts install and facts handlers still running, for a graceful drain.
+var inflight sync.WaitGroup
+
+// errShutdown marks runs killed because the worker is stopping.
+var errShutdown = errors.New("worker shutting down")
+
+// flushTimeout is how long cancelled handlers get to publish their final status
+// before the worker exits.
+const flushTimeout = 15 * time.Second
+
+// watchSignals turns SIGINT/SIGTERM into shutdown. SIGINT cancels ctx right away,
+// killing running playbooks. With SHUTDOWN_GRACE set and the worker ready, a first
+// SIGTERM only closes draining so in-flight work can finish; any further signal
+// cancels ctx.
+func watchSignals(cancel context.CancelFunc, ready *atomic.Bool) (draining <-chan struct{}) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	drain := make(chan struct{})
+
+	go func() {
+		drainStarted := false
+		for s := range sigs {
+			switch {
+			case drainStarted:
+				slog.Info("second signal, forcing exit", "signal", s.String())
+			case s == syscall.SIGTERM && cfg.ShutdownGrace > 0 && ready.Load():
+				slog.Info("SIGTERM: no longer accepting requests, draining in-flight runs", "grace", cfg.ShutdownGrace)
+				drainStarted = true
+				close(drain)
+				continue
+			default:
+				slog.Info("cancelling in-flight runs", "signal", s.String())
+			}
+			cancel()
+			return
+		}
+	}()
+	return drain
+}
+
+// waitInflight waits for in-flight handlers to return, up to grace. It reports
+// whether they all finished in time.
+func waitInflight(ctx context.Context, grace time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace):
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// drainConn drains nc, so statuses still buffered reach the server, and waits for it
+// to close (closed is closed by the ClosedHandler). A wedged connection is closed
+// after timeout instead, so shutdown stays bounded.
+func drainConn(nc *nats.Conn, closed <-chan struct{}, timeout time.Duration) {
+	if err := nc.Drain(); err != nil {
+		slog.Warn("drain NATS connection failed, closing it", "err", err)
+		nc.Close()
+		return
+	}
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		slog.Warn("NATS drain timed out, closing the connection", "timeout", timeout)
+		nc.Close()
+	}
+}
+
+// flushInflight gives handlers whose runs were just cancelled a moment to publish
+// their error statuses, so no request ends without a final status.
+func flushInflight() {
+	if !waitInflight(context.Background(), flushTimeout) {
+		slog.Warn("handlers still running at exit", "waited", flushTimeout)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestDrainConn(t *testing.T) {
+	tests := []struct {
+		name        string
+		closeSignal bool // the ClosedHandler fires, as it does once a drain completes
+		wantTimeout bool
+	}{
+		{name: "drained", closeSignal: true},
+		{name: "wedged", wantTimeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := startNATS(t)
+			sub, err := recv.SubscribeSync("test.drain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			closed := make(chan struct{})
+			var opts []nats.Option
+			if tt.closeSignal {
+				opts = append(opts, nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+			}
+			nc, err := nats.Connect(recv.ConnectedUrl(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := nc.Publish("test.drain", []byte("last status")); err != nil {
+				t.Fatal(err)
+			}
+
+			const timeout = 300 * time.Millisecond
+			start := time.Now()
+			drainConn(nc, closed, timeout)
+			took := time.Since(start)
+
+			if !nc.IsClosed() {
+				t.Error("connection still open after drainConn")
+			}
+			if timedOut := took >= timeout; timedOut != tt.wantTimeout {
+				t.Errorf("drainConn took %v, want timeout %v", took, tt.wantTimeout)
+			}
+			if tt.closeSignal {
+				// the drain flushed the publish before closing
+				if msg, err := sub.NextMsg(time.Second); err != nil || string(msg.Data) != "last status" {
+					t.Errorf("message after drain: %v, %v", msg, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// sshOptionRe is one "-o Name=value" ssh option as ssh_common_args may pass it:
+// no quoting or shell syntax, since ansible splits the string shell-style.
+var sshOptionRe = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)=([A-Za-z0-9@%+,.:/_-]+)$`)
+
+// allowedSSHOptions are the ssh options a request may set (lower case): timeouts,
+// keepalives, algorithms and authentication methods. Anything that names a local
+// file, socket, provider library or command, or forwards ports, stays out.
+// StrictHostKeyChecking can't weaken a request's known_hosts: ssh takes the first value,
+// and the worker's comes first.
+var allowedSSHOptions = []string{
+	"connecttimeout", "connectionattempts", "serveraliveinterval", "serveralivecountmax", "tcpkeepalive",
+	"stricthostkeychecking", "checkhostip",
+	"ciphers", "macs", "kexalgorithms", "hostkeyalgorithms", "pubkeyacceptedalgorithms", "pubkeyacceptedkeytypes",
+	"compression", "ipqos", "addressfamily", "loglevel", "rekeylimit",
+	"preferredauthentications", "pubkeyauthentication", "passwordauthentication",
+	"kbdinteractiveauthentication", "gssapiauthentication", "numberofpasswordprompts",
+}
+
+// validateSSHCommonArgs accepts ssh_common_args made only of "-o Name=value"
+// options (also written "-oName=value"), all of them in allowedSSHOptions.
+func validateSSHCommonArgs(args string) error {
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		opt, ok := strings.CutPrefix(fields[i], "-o")
+		if !ok {
+			return fmt.Errorf("invalid ssh_common_args %q (only -o Name=value options are allowed)", fields[i])
+		}
+		if opt == "" && i+1 < len(fields) {
+			i++
+			opt = fields[i]
+		}
+		m := sshOptionRe.FindStringSubmatch(opt)
+		if m == nil {
+			return fmt.Errorf("invalid ssh_common_args option %q (must be Name=value without quotes or spaces)", opt)
+		}
+		if !slices.Contains(allowedSSHOptions, strings.ToLower(m[1])) {
+			return fmt.Errorf("ssh_common_args may not set %s", m[1])
+		}
+	}
+	return nil
+}
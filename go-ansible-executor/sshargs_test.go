@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSSHCommonArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		wantErr string
+	}{
+		{args: ""},
+		{args: "-o ServerAliveInterval=30 -o ConnectTimeout=5"},
+		{args: "-oCompression=yes"},
+		{args: "-o Ciphers=aes256-gcm@openssh.com,aes128-ctr"},
+		{args: "-v", wantErr: `invalid ssh_common_args "-v"`},
+		{args: "ServerAliveInterval=30", wantErr: "only -o Name=value options"},
+		{args: "-o", wantErr: `invalid ssh_common_args option ""`},
+		{args: "-o ServerAliveInterval", wantErr: "must be Name=value"},
+		{args: "-o User='root'", wantErr: "must be Name=value"},
+		{args: "-o SendEnv=$HOME", wantErr: "must be Name=value"},
+		{args: "-o ProxyCommand=nc", wantErr: "may not set ProxyCommand"},
+		{args: "-o proxyjump=jump", wantErr: "may not set proxyjump"},
+		{args: "-o PermitLocalCommand=yes", wantErr: "may not set PermitLocalCommand"},
+		{args: "-oStrictHostKeyChecking=no"}, // the worker's own value comes first
+		{args: "-o IdentityFile=/root/.ssh/id_rsa", wantErr: "may not set IdentityFile"},
+		{args: "-o ControlPath=/tmp/x", wantErr: "may not set ControlPath"},
+		{args: "-o PKCS11Provider=/usr/lib/p11.so", wantErr: "may not set PKCS11Provider"},
+		{args: "-o UserKnownHostsFile=/dev/null", wantErr: "may not set UserKnownHostsFile"},
+	}
+	for _, tt := range tests {
+		err := validateSSHCommonArgs(tt.args)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tt.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: err = %v, want it to contain %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
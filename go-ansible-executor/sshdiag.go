@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+)
+
+// SSHDiagnostic classifies why ansible could not get an SSH session to the host.
+type SSHDiagnostic struct {
+	Reason string `json:"reason"` // one of the sshReason* consts
+	Kind   string `json:"kind"`   // "auth" | "network" | "hostkey"
+	Detail string `json:"detail"` // the output line the reason was derived from
+}
+
+// Reason codes for SSHDiagnostic
+const (
+	sshReasonPermissionDenied = "PERMISSION_DENIED"
+	sshReasonAuthFailure      = "AUTH_FAILURE"
+	sshReasonTimeout          = "CONNECTION_TIMEOUT"
+	sshReasonHostKey          = "HOST_KEY_MISMATCH"
+)
+
+// sshSignatures are matched case-insensitively against each SSH failure line, in order.
+var sshSignatures = []struct {
+	needle, reason, kind string
+}{
+	{"host key verification failed", sshReasonHostKey, "hostkey"},
+	{"permission denied", sshReasonPermissionDenied, "auth"},
+	{"authentication failure", sshReasonAuthFailure, "auth"},
+	{"connection timed out", sshReasonTimeout, "network"},
+}
+
+// sshFailureMarkers pick out the output lines about the connection itself: an
+// UNREACHABLE! host result, or ssh's own message. A task's error message, e.g. a
+// "Permission denied" on a file, says nothing about SSH.
+var sshFailureMarkers = []string{"unreachable!", "failed to connect to the host via ssh"}
+
+// diagnoseSSH returns the first known SSH failure signature in the SSH failure
+// lines of ansible output, or nil if none is present.
+func diagnoseSSH(output []byte) *SSHDiagnostic {
+	sc := bufio.NewScanner(strings.NewReader(string(output)))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		lower := strings.ToLower(line)
+		if !slices.ContainsFunc(sshFailureMarkers, func(m string) bool { return strings.Contains(lower, m) }) {
+			continue
+		}
+		for _, sig := range sshSignatures {
+			if strings.Contains(lower, sig.needle) {
+				return &SSHDiagnostic{
+					Reason: sig.reason,
+					Kind:   sig.kind,
+					Detail: truncate(strings.TrimSpace(line), 500),
+				}
+			}
+		}
+	}
+	return nil
+}
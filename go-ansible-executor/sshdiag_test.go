@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestDiagnoseSSH(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *SSHDiagnostic
+	}{
+		{
+			name: "permission denied",
+			output: "TASK [Gathering Facts] *********************************************************\n" +
+				`fatal: [10.0.0.1]: UNREACHABLE! => {"changed": false, "msg": "Failed to connect to the host via ssh: admin@10.0.0.1: Permission denied (publickey,password).", "unreachable": true}` + "\n",
+			want: &SSHDiagnostic{
+				Reason: sshReasonPermissionDenied,
+				Kind:   "auth",
+				Detail: `fatal: [10.0.0.1]: UNREACHABLE! => {"changed": false, "msg": "Failed to connect to the host via ssh: admin@10.0.0.1: Permission denied (publickey,password).", "unreachable": true}`,
+			},
+		},
+		{
+			name:   "authentication failure",
+			output: `fatal: [10.0.0.1]: UNREACHABLE! => {"msg": "Authentication failure.", "unreachable": true}` + "\n",
+			want:   &SSHDiagnostic{Reason: sshReasonAuthFailure, Kind: "auth", Detail: `fatal: [10.0.0.1]: UNREACHABLE! => {"msg": "Authentication failure.", "unreachable": true}`},
+		},
+		{
+			name:   "connection timed out",
+			output: `fatal: [10.0.0.1]: UNREACHABLE! => {"changed": false, "msg": "Failed to connect to the host via ssh: ssh: connect to host 10.0.0.1 port 22: Connection timed out", "unreachable": true}`,
+			want: &SSHDiagnostic{
+				Reason: sshReasonTimeout,
+				Kind:   "network",
+				Detail: `fatal: [10.0.0.1]: UNREACHABLE! => {"changed": false, "msg": "Failed to connect to the host via ssh: ssh: connect to host 10.0.0.1 port 22: Connection timed out", "unreachable": true}`,
+			},
+		},
+		{
+			name: "host key mismatch",
+			output: "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n" +
+				"@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n" +
+				`fatal: [10.0.0.1]: UNREACHABLE! => {"msg": "Failed to connect to the host via ssh: Host key verification failed.", "unreachable": true}` + "\n",
+			want: &SSHDiagnostic{
+				Reason: sshReasonHostKey,
+				Kind:   "hostkey",
+				Detail: `fatal: [10.0.0.1]: UNREACHABLE! => {"msg": "Failed to connect to the host via ssh: Host key verification failed.", "unreachable": true}`,
+			},
+		},
+		{
+			name:   "host key wins over permission denied on the same line",
+			output: "Failed to connect to the host via ssh: Host key verification failed. Permission denied\n",
+			want:   &SSHDiagnostic{Reason: sshReasonHostKey, Kind: "hostkey", Detail: "Failed to connect to the host via ssh: Host key verification failed. Permission denied"},
+		},
+		{
+			name:   "task failure",
+			output: `fatal: [10.0.0.1]: FAILED! => {"changed": false, "msg": "No package matching 'postgresql16' is available"}` + "\n",
+		},
+		{
+			name:   "task permission denied",
+			output: `fatal: [10.0.0.1]: FAILED! => {"changed": false, "msg": "[Errno 13] Permission denied: '/etc/postgresql/pg_hba.conf'"}` + "\n",
+		},
+		{
+			name:   "task timed out",
+			output: `fatal: [10.0.0.1]: FAILED! => {"changed": false, "msg": "Request failed: <urlopen error [Errno 110] Connection timed out>", "url": "https://example.com/pgdg.rpm"}` + "\n",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diagnoseSSH([]byte(tt.output))
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("diagnoseSSH() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
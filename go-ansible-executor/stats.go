@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+)
+
+// subjectStats answers with this worker's load and, under JetStream, the backlog.
+const subjectStats = "db.install.stats"
+
+// jetStreamSub is the JetStream install subscription; nil under core NATS.
+var jetStreamSub atomic.Pointer[nats.Subscription]
+
+// Backlog is the JetStream consumer's view of pending install requests. It is the
+// same for every worker, since they share the durable consumer.
+type Backlog struct {
+	Pending     uint64 `json:"pending"`     // not delivered to any worker yet
+	AckPending  int    `json:"ack_pending"` // delivered, not acked yet (running or about to be redelivered)
+	Redelivered int    `json:"redelivered"`
+}
+
+// WorkerStats is the reply on db.install.stats.
+type WorkerStats struct {
+	WorkerID  string            `json:"worker_id"`
+	Running   int64             `json:"running"`   // playbooks running now
+	Queued    int               `json:"queued"`    // runs waiting for a MAX_CONCURRENT_RUNS slot
+	Processed uint64            `json:"processed"` // final statuses since startup
+	Installs  map[string]uint64 `json:"installs"`  // the same, by status
+	JetStream *Backlog          `json:"jetstream,omitempty"`
+}
+
+// jetStreamBacklog asks the server for the consumer's counts; nil under core NATS
+// or if the server didn't answer.
+func jetStreamBacklog() *Backlog {
+	sub := jetStreamSub.Load()
+	if sub == nil {
+		return nil
+	}
+	ci, err := sub.ConsumerInfo()
+	if err != nil {
+		slog.Warn("jetstream consumer info failed", "consumer", cfg.JetStreamDurable, "err", err)
+		return nil
+	}
+	return &Backlog{Pending: ci.NumPending, AckPending: ci.NumAckPending, Redelivered: ci.NumRedelivered}
+}
+
+func currentStats() WorkerStats {
+	st := WorkerStats{
+		WorkerID:  cfg.WorkerID,
+		Running:   metrics.running.Load(),
+		Installs:  map[string]uint64{},
+		JetStream: jetStreamBacklog(),
+	}
+	if runSlots != nil {
+		st.Queued = runSlots.queued()
+	}
+	metrics.mu.Lock()
+	for s, n := range metrics.installs {
+		st.Installs[s] = n
+		st.Processed += n
+	}
+	metrics.mu.Unlock()
+	return st
+}
+
+// handleStats replies on db.install.stats. Every worker answers, so collect all
+// replies to see the whole queue group.
+func handleStats(msg *nats.Msg) {
+	if msg.Reply == "" {
+		return
+	}
+	data, err := json.Marshal(currentStats())
+	if err != nil {
+		slog.Error("marshal stats failed", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		slog.Warn("reply to stats request failed", "err", err)
+	}
+}
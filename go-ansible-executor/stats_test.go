@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestHandleStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		jetStream bool
+		want      *Backlog
+	}{
+		{name: "core nats"},
+		{name: "jetstream", jetStream: true, want: &Backlog{AckPending: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			cfg.WorkerID, cfg.JetStream = "w1", tt.jetStream
+			nc := startNATS(t, func(o *server.Options) { o.JetStream, o.StoreDir = true, t.TempDir() })
+			jetStreamSub.Store(nil)
+			t.Cleanup(func() { jetStreamSub.Store(nil) })
+
+			// one run holds the only slot and one waits for it
+			slots := newAdmission(1)
+			runSlots = slots
+			if err := slots.acquire(context.Background(), 0); err != nil {
+				t.Fatal(err)
+			}
+			defer slots.release()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				if slots.acquire(ctx, 0) == nil {
+					slots.release()
+				}
+			}()
+			for runSlots.queued() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if tt.jetStream {
+				// a delivered request that isn't acked yet
+				delivered := make(chan struct{}, 1)
+				sub, err := subscribeInstallJetStream(nc, func(*nats.Msg) { delivered <- struct{}{} })
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer sub.Unsubscribe()
+				jetStreamSub.Store(sub)
+				if err := nc.Publish(cfg.SubjectInstall, []byte(`{"id": 7}`)); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case <-delivered:
+				case <-time.After(5 * time.Second):
+					t.Fatal("no delivery")
+				}
+			}
+
+			sub, err := nc.Subscribe(subjectStats, handleStats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+			msg, err := nc.Request(subjectStats, nil, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got WorkerStats
+			if err := json.Unmarshal(msg.Data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.WorkerID != "w1" || got.Queued != 1 {
+				t.Errorf("worker_id %q queued %d, want w1 and 1", got.WorkerID, got.Queued)
+			}
+			switch {
+			case tt.want == nil && got.JetStream != nil:
+				t.Errorf("jetstream = %+v under core NATS", *got.JetStream)
+			case tt.want != nil && (got.JetStream == nil || *got.JetStream != *tt.want):
+				t.Errorf("jetstream = %+v, want %+v", got.JetStream, *tt.want)
+			}
+		})
+	}
+}
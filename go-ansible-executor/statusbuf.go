@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// pendingStatuses holds status publishes NATS couldn't take while disconnected,
+// oldest first, until the reconnect handler replays them.
+var pendingStatuses struct {
+	mu   sync.Mutex
+	msgs []*nats.Msg
+}
+
+// publishOrBuffer publishes msg. While NATS is reconnecting and its own reconnect
+// buffer is full, msg is kept (up to STATUS_BUFFER_SIZE messages) and nil returned.
+// Once something is buffered, later messages queue behind it to keep their order.
+func publishOrBuffer(nc *nats.Conn, msg *nats.Msg) error {
+	pendingStatuses.mu.Lock()
+	defer pendingStatuses.mu.Unlock()
+
+	if len(pendingStatuses.msgs) == 0 {
+		err := nc.PublishMsg(msg)
+		if !errors.Is(err, nats.ErrReconnectBufExceeded) && !errors.Is(err, nats.ErrConnectionReconnecting) {
+			return err
+		}
+		slog.Warn("nats unavailable, buffering status publishes until reconnect", "err", err)
+	}
+	if len(pendingStatuses.msgs) >= cfg.StatusBufferSize {
+		dropped := pendingStatuses.msgs[0]
+		pendingStatuses.msgs = pendingStatuses.msgs[1:]
+		slog.Warn("status buffer full, dropped oldest message", "subject", dropped.Subject, "size", cfg.StatusBufferSize)
+	}
+	pendingStatuses.msgs = append(pendingStatuses.msgs, msg)
+	return nil
+}
+
+// flushPendingStatuses replays buffered publishes in order; it runs on reconnect.
+// Whatever NATS still can't take stays buffered for the next reconnect.
+func flushPendingStatuses(nc *nats.Conn) {
+	pendingStatuses.mu.Lock()
+	defer pendingStatuses.mu.Unlock()
+
+	sent := 0
+	for _, msg := range pendingStatuses.msgs {
+		if err := nc.PublishMsg(msg); err != nil {
+			slog.Warn("replay buffered status failed", "subject", msg.Subject, "err", err)
+			break
+		}
+		sent++
+	}
+	pendingStatuses.msgs = pendingStatuses.msgs[sent:]
+	if sent > 0 {
+		slog.Info("replayed buffered status publishes", "count", sent, "remaining", len(pendingStatuses.msgs))
+	}
+}
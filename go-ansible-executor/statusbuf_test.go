@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestPublishOrBufferReplaysOnReconnect(t *testing.T) {
+	defer func(n int) { cfg.StatusBufferSize = n }(cfg.StatusBufferSize)
+	cfg.StatusBufferSize = 2
+
+	serve := func(port int) *server.Server {
+		ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ns.Start()
+		if !ns.ReadyForConnections(5 * time.Second) {
+			t.Fatal("nats server not ready")
+		}
+		return ns
+	}
+	ns := serve(-1)
+	port := ns.Addr().(*net.TCPAddr).Port
+	reconnected := make(chan struct{}, 1)
+	nc, err := nats.Connect(ns.ClientURL(),
+		nats.ReconnectBufSize(-1), // no client-side buffer: publishes fail while reconnecting
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.MaxReconnects(-1),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			flushPendingStatuses(nc)
+			reconnected <- struct{}{}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	sub, err := nc.SubscribeSync(cfg.SubjectStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	ns.Shutdown()
+	ns.WaitForShutdown()
+	for deadline := time.Now().Add(5 * time.Second); !nc.IsReconnecting(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("client never noticed the outage")
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		if err := publishOrBuffer(nc, &nats.Msg{Subject: cfg.SubjectStatus, Data: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+
+	ns = serve(port)
+	defer ns.Shutdown()
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("client never reconnected")
+	}
+	// the oldest didn't fit in STATUS_BUFFER_SIZE
+	for _, want := range []string{"2", "3"} {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		if string(msg.Data) != want {
+			t.Errorf("replayed %q, want %q", msg.Data, want)
+		}
+	}
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Errorf("unexpected extra message %q", msg.Data)
+	}
+}
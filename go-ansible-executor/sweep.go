@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// activeInventories holds the paths of inventories in use by a running handler,
+// so a sweep never deletes one out from under a playbook.
+var activeInventories sync.Map
+
+// sweepInventories removes leftover vm_* inventory files in dir older than maxAge,
+// skipping any that are in use. It returns how many were removed.
+func sweepInventories(dir string, maxAge time.Duration) int {
+	paths, err := filepath.Glob(filepath.Join(dir, "vm_*"))
+	if err != nil {
+		slog.Warn("sweep inventories failed", "err", err)
+		return 0
+	}
+	removed := 0
+	for _, p := range paths {
+		if _, busy := activeInventories.Load(p); busy {
+			continue
+		}
+		info, err := os.Lstat(p)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if rmErr := os.Remove(p); rmErr != nil {
+			slog.Warn("remove inventory failed", "path", p, "err", rmErr)
+			continue
+		}
+		slog.Info("removed inventory", "path", p)
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryInventoryOnFullDisk(t *testing.T) {
+	tests := []struct {
+		name     string
+		full     int   // writes that hit ENOSPC
+		otherErr error // returned by the write after those
+		wantErr  error
+	}{
+		{name: "space freed", full: 2},
+		{name: "still full", full: diskFullRetries + 1, wantErr: syscall.ENOSPC},
+		{name: "other error", full: 1, otherErr: fs.ErrPermission, wantErr: fs.ErrPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			cfg = Config{InventoryDir: "inventories", StaleInventoryAge: time.Hour}
+			defer func(d time.Duration) { diskFullRetryDelay = d }(diskFullRetryDelay)
+			diskFullRetryDelay = time.Millisecond
+			writes := 0
+			defer func(f func(string, int, os.FileMode) (*os.File, error)) { createFile = f }(createFile)
+			createFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+				writes++
+				switch {
+				case writes <= tt.full:
+					return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOSPC}
+				case writes == tt.full+1 && tt.otherErr != nil:
+					return nil, tt.otherErr
+				}
+				return os.OpenFile(name, flag, perm)
+			}
+			if err := os.MkdirAll(cfg.InventoryDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			stale := filepath.Join(cfg.InventoryDir, "vm_1_old.ini")
+			if err := os.WriteFile(stale, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-2 * cfg.StaleInventoryAge)
+			os.Chtimes(stale, old, old)
+			req := testRequest()
+
+			// handleMessage's first attempt
+			_, err := writeInventory(req, "run1")
+			if !errors.Is(err, syscall.ENOSPC) {
+				t.Fatalf("first write = %v, want ENOSPC", err)
+			}
+			var deferred []InstallStatus
+			path, err := retryInventoryOnFullDisk(context.Background(), req, "run1", func(st InstallStatus) {
+				deferred = append(deferred, st)
+			})
+			defer removeInventory(path)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("retryInventoryOnFullDisk() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if _, statErr := os.Stat(path); statErr != nil {
+					t.Errorf("inventory not written: %v", statErr)
+				}
+			}
+			if len(deferred) != 1 || deferred[0].Stage != stageDeferred || deferred[0].ErrorCode != errCodeNoSpace {
+				t.Errorf("published %+v, want one deferred status with %s", deferred, errCodeNoSpace)
+			}
+			if _, statErr := os.Stat(stale); !errors.Is(statErr, fs.ErrNotExist) {
+				t.Errorf("stale inventory not swept: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestSweepInventories(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxAge time.Duration // 0 as at startup with the instance lock
+		want   []string      // files left
+	}{
+		{name: "stale only", maxAge: time.Hour, want: []string{"notes.txt", "vm_2_active.ini", "vm_3_new.ini"}},
+		{name: "everything unused", want: []string{"notes.txt", "vm_2_active.ini"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			old := time.Now().Add(-2 * time.Hour)
+			for _, name := range []string{"vm_1_old.ini", "vm_1_old.key", "vm_2_active.ini", "vm_3_new.ini", "notes.txt"} {
+				p := filepath.Join(dir, name)
+				if err := os.WriteFile(p, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if name != "vm_3_new.ini" {
+					os.Chtimes(p, old, old)
+				}
+			}
+			active := filepath.Join(dir, "vm_2_active.ini")
+			activeInventories.Store(active, struct{}{})
+			defer activeInventories.Delete(active)
+
+			n := sweepInventories(dir, tt.maxAge)
+			var left []string
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				left = append(left, e.Name())
+			}
+			if !slices.Equal(left, tt.want) || n != 5-len(tt.want) {
+				t.Errorf("sweep removed %d, left %v; want %v left", n, left, tt.want)
+			}
+		})
+	}
+}
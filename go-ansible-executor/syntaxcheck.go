@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// syntaxCheckTimeout bounds the ansible-playbook --syntax-check run (SYNTAX_CHECK).
+const syntaxCheckTimeout = time.Minute
+
+// errPlaybookInvalid marks a run stopped because its playbook failed --syntax-check.
+var errPlaybookInvalid = errors.New("playbook failed syntax check")
+
+// syntaxCheck runs ansible-playbook --syntax-check with res's playbook and args,
+// which parses the playbook without connecting to any host. If it doesn't pass,
+// res gets the check's command line, output and error, and false is returned.
+func (ir *installRun) syntaxCheck(parent context.Context, res *playResult, env []string) bool {
+	args := append(slices.Clone(res.Args), "--syntax-check")
+	var code int
+	var out []byte
+	var err error
+	if slotErr := runSlots.do(parent, ir.priority, func() {
+		code, out, err = ir.runner.Run(parent, res.Playbook, args, env, syntaxCheckTimeout, outputPrefix(ir.req.ID))
+	}); slotErr != nil {
+		err = fmt.Errorf("cancelled while waiting for a run slot: %w", context.Cause(parent))
+	}
+	if err == nil && code == 0 {
+		return true
+	}
+
+	res.Args, res.ExitCode = args, code
+	res.Output = redactSecrets(out, ir.req.secrets())
+	var exitErr *exec.ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		res.Err, res.Category = fmt.Errorf("%w (exit %d)", errPlaybookInvalid, code), catPlaybook
+	} else {
+		// cancelled, timed out or couldn't run; says nothing about the playbook
+		res.Err = fmt.Errorf("syntax check: %w", err)
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestSyntaxCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		run          fakeRun
+		wantOK       bool
+		wantCode     string
+		wantCategory string
+	}{
+		{name: "passes", wantOK: true},
+		{
+			name:         "fails",
+			run:          fakeRun{code: 4, output: "ERROR! 'hosts' is required", err: &exec.ExitError{}},
+			wantCode:     errCodePlaybookInvalid,
+			wantCategory: catPlaybook,
+		},
+		{name: "non-zero exit", run: fakeRun{code: 1}, wantCode: errCodePlaybookInvalid, wantCategory: catPlaybook},
+		{
+			name:         "cancelled",
+			run:          fakeRun{code: exitCancelled, err: fmt.Errorf("ansible-playbook killed: %w", errCancelled)},
+			wantCategory: catCancelled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ir := installRun{req: testRequest(), runner: playbookResults{"postgresql.yml": tt.run}}
+			res := playResult{DBType: "postgresql", Playbook: "playbooks/postgresql.yml", Args: []string{"-i", "inv.ini", "playbooks/postgresql.yml"}}
+
+			ok := ir.syntaxCheck(context.Background(), &res, nil)
+
+			if ok != tt.wantOK {
+				t.Fatalf("syntaxCheck() = %v, want %v", ok, tt.wantOK)
+			}
+			if ok {
+				if slices.Contains(res.Args, "--syntax-check") {
+					t.Errorf("passing check changed the run's args: %v", res.Args)
+				}
+				return
+			}
+			if _, _, code := res.outcome(); code != tt.wantCode {
+				t.Errorf("error_code = %q, want %q", code, tt.wantCode)
+			}
+			if got := res.category(); got != tt.wantCategory {
+				t.Errorf("category = %q, want %q", got, tt.wantCategory)
+			}
+			if !slices.Contains(res.Args, "--syntax-check") {
+				t.Errorf("command line %v doesn't show the check", res.Args)
+			}
+		})
+	}
+}
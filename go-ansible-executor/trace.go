@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing with the OpenTelemetry SDK: W3C trace context in, OTLP/HTTP out. Spans
+// are exported only once startTracing has installed the SDK provider; until then
+// the global provider is a no-op that still carries a message's traceparent, so
+// its trace_id is reported either way.
+
+const (
+	tracerName = "go-ansible-executor"
+
+	spanQueueSize     = 2048
+	spanExportTimeout = 10 * time.Second
+)
+
+// traceContext reads the W3C traceparent header of install messages.
+var traceContext = propagation.TraceContext{}
+
+// natsCarrier lets the propagator read nats.Header, whose keys, unlike
+// http.Header's, are used as sent.
+type natsCarrier nats.Header
+
+func (c natsCarrier) Get(key string) string { return nats.Header(c).Get(key) }
+func (c natsCarrier) Set(key, value string) { nats.Header(c).Set(key, value) }
+
+func (c natsCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// span is an OpenTelemetry span with the helpers the handlers use.
+type span struct {
+	trace.Span
+}
+
+// remoteParent is the span context of the message's traceparent header, as a
+// parent for startSpan; ctx as is if there's none or it doesn't parse.
+func remoteParent(ctx context.Context, h nats.Header) context.Context {
+	if h == nil {
+		return ctx
+	}
+	return traceContext.Extract(ctx, natsCarrier(h))
+}
+
+// startSpan starts a span as a child of the one in ctx (a new trace if there's
+// none) and returns a ctx carrying it. The first span of a message is a consumer
+// span.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	kind := trace.SpanKindInternal
+	if parent := trace.SpanContextFromContext(ctx); !parent.IsValid() || parent.IsRemote() {
+		kind = trace.SpanKindConsumer
+	}
+	ctx, s := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &span{s}
+}
+
+// TraceID is the span's trace ID in hex, "" if it has none (tracing off and no
+// traceparent).
+func (s *span) TraceID() string {
+	if sc := s.SpanContext(); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+func (s *span) SetAttr(key string, value any) {
+	switch v := value.(type) {
+	case int:
+		s.SetAttributes(attribute.Int(key, v))
+	case bool:
+		s.SetAttributes(attribute.Bool(key, v))
+	case string:
+		s.SetAttributes(attribute.String(key, v))
+	default:
+		s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// SetError marks the span failed with msg.
+func (s *span) SetError(msg string) {
+	s.SetStatus(codes.Error, msg)
+}
+
+// End finishes the span.
+func (s *span) End() {
+	s.Span.End()
+}
+
+// endInstall finishes a handleMessage span with the final status.
+func (s *span) endInstall(st InstallStatus) {
+	s.SetAttr("install.status", st.Status)
+	if st.Status == "error" {
+		s.SetAttr("install.category", st.Category)
+		if st.ErrorCode != "" {
+			s.SetAttr("install.error_code", st.ErrorCode)
+		}
+		s.SetError(st.Error)
+	}
+	s.End()
+}
+
+// endPlay finishes a playbook span with the run's result.
+func (s *span) endPlay(r playResult) {
+	s.SetAttr("ansible.exit_code", r.ExitCode)
+	if status, errMsg, _ := r.outcome(); status == "error" {
+		if errMsg == "" {
+			errMsg = fmt.Sprintf("exit %d", r.ExitCode)
+		}
+		s.SetAttr("install.category", r.category())
+		s.SetError(errMsg)
+	}
+	s.End()
+}
+
+// startTracing exports spans to cfg.OTLPEndpoint in batches. The returned func
+// sends what is still queued; call it once no more spans will end.
+func startTracing() (stop func()) {
+	url := strings.TrimSuffix(cfg.OTLPEndpoint, "/") + "/v1/traces"
+	exp, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(url), otlptracehttp.WithTimeout(spanExportTimeout))
+	if err != nil {
+		slog.Warn("not exporting traces", "url", url, "err", err)
+		return func() {}
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		// the collector is down or slow; tracing must not hold up installs
+		slog.Warn("export spans failed", "err", err)
+	}))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp, sdktrace.WithMaxQueueSize(spanQueueSize)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.OTelServiceName),
+			attribute.String("service.instance.id", cfg.WorkerID),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	slog.Info("exporting traces", "url", url, "service", cfg.OTelServiceName)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), spanExportTimeout)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			slog.Warn("export spans failed", "err", err)
+		}
+	}
+}
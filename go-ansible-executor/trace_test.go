@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// With tracing off, a message's traceparent still gives its trace_id.
+func TestTraceIDTracingOff(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+	}{
+		{name: "traceparent", traceparent: testTraceparent, want: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "not sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", want: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "zero trace id", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "not hex", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := nats.Header{}
+			if tt.traceparent != "" {
+				h.Set("traceparent", tt.traceparent)
+			}
+			_, s := startSpan(remoteParent(context.Background(), h), "handleMessage")
+			defer s.End()
+			if got := s.TraceID(); got != tt.want {
+				t.Errorf("trace id = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+
+	h := nats.Header{}
+	h.Set("traceparent", testTraceparent)
+	ctx, root := startSpan(remoteParent(context.Background(), h), "handleMessage")
+	_, play := startSpan(ctx, "runPlaybook")
+	play.endPlay(playResult{ExitCode: 2})
+	root.endInstall(InstallStatus{Status: "error", Category: catPlaybook, Error: "exit 2"})
+
+	got := exp.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(got))
+	}
+	playSpan, rootSpan := got[0], got[1]
+	for _, s := range got {
+		if s.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: trace id %s, want the traceparent's", s.Name, s.SpanContext.TraceID())
+		}
+		if s.Status.Code != codes.Error {
+			t.Errorf("%s: status %+v, want an error", s.Name, s.Status)
+		}
+	}
+	if rootSpan.Parent.SpanID().String() != "00f067aa0ba902b7" || rootSpan.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("handleMessage: parent %s kind %s, want the caller's span and consumer", rootSpan.Parent.SpanID(), rootSpan.SpanKind)
+	}
+	if playSpan.Parent.SpanID() != rootSpan.SpanContext.SpanID() || playSpan.SpanKind != trace.SpanKindInternal {
+		t.Errorf("runPlaybook: parent %s kind %s, want %s and internal", playSpan.Parent.SpanID(), playSpan.SpanKind, rootSpan.SpanContext.SpanID())
+	}
+	if playSpan.Status.Description != "exit 2" {
+		t.Errorf("runPlaybook: status message %q, want exit 2", playSpan.Status.Description)
+	}
+}
+
+func TestStartTracingExports(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("posted to %s", r.URL.Path)
+		}
+		posts.Add(1)
+	}))
+	defer srv.Close()
+
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	defer func(c Config) { cfg = c }(cfg)
+	cfg.OTLPEndpoint = srv.URL + "/"
+	stop := startTracing()
+	_, s := startSpan(context.Background(), "handleMessage")
+	s.End()
+	stop()
+
+	if posts.Load() == 0 {
+		t.Error("no spans exported at stop")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// octal is a permission value shown in octal in the config view, e.g. "0077".
+type octal int
+
+func (o octal) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%04o"`, int(o))), nil
+}
+
+// envUmask parses an octal umask env value, falling back to def. Group and other
+// bits are always masked, so a value can only make created files stricter.
+func envUmask(k string, def octal) octal {
+	m := def
+	if v := os.Getenv(k); v != "" {
+		n, err := strconv.ParseUint(v, 8, 12)
+		if err != nil {
+			slog.Warn("invalid env value, using default", "env", k, "value", v, "default", fmt.Sprintf("%04o", int(def)))
+		} else {
+			m = octal(n)
+		}
+	}
+	if m&0o077 != 0o077 {
+		slog.Warn("umask leaves group/other bits unmasked, masking them", "env", k, "value", fmt.Sprintf("%04o", int(m)), "using", fmt.Sprintf("%04o", int(m|0o077)))
+		m |= 0o077
+	}
+	return m
+}
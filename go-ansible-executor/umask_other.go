@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// withUmask just runs create where there is no umask.
+func withUmask(create func() error) error {
+	return create()
+}
@@ -0,0 +1,116 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestEnvUmask(t *testing.T) {
+	tests := []struct {
+		value string
+		want  octal
+	}{
+		{"", 0o077},
+		{"0077", 0o077},
+		{"0177", 0o177},
+		{"0027", 0o077}, // group/other always masked
+		{"0022", 0o077},
+		{"0", 0o077},
+		{"0o77", 0o077}, // invalid: default
+		{"999", 0o077},
+		{"17777", 0o077}, // more than 12 bits
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("FILE_UMASK", tt.value)
+			if got := envUmask("FILE_UMASK", 0o077); got != tt.want {
+				t.Errorf("envUmask(%q) = %04o, want %04o", tt.value, int(got), int(tt.want))
+			}
+		})
+	}
+}
+
+func TestWithUmask(t *testing.T) {
+	old := syscall.Umask(0o022)
+	defer syscall.Umask(old)
+
+	tests := []struct {
+		name  string
+		umask octal
+		perm  os.FileMode
+		want  os.FileMode
+	}{
+		{name: "default", umask: 0o077, perm: 0o666, want: 0o600},
+		{name: "stricter", umask: 0o177, perm: 0o666, want: 0o600},
+		{name: "owner read-only", umask: 0o277, perm: 0o666, want: 0o400},
+		{name: "executable", umask: 0o077, perm: 0o777, want: 0o700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.FileUmask = tt.umask
+			path := filepath.Join(t.TempDir(), "f")
+			err := withUmask(func() error {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, tt.perm)
+				if err != nil {
+					return err
+				}
+				return f.Close()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tt.want {
+				t.Errorf("mode = %04o, want %04o", got, tt.want)
+			}
+			if got := syscall.Umask(0o022); got != 0o022 {
+				t.Errorf("umask after withUmask = %04o, want it restored to 0022", got)
+			}
+		})
+	}
+}
+
+func TestInventoryFilesPrivate(t *testing.T) {
+	inTempDir(t)
+	cfg = Config{InventoryDir: "inventories", FileUmask: 0o077, DebugInventoryDir: filepath.Join(t.TempDir(), "debug")}
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	req := testRequest()
+	req.SSHPrivateKey = testKey
+	req.KnownHosts = "10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExample"
+
+	invPath, err := writeInventory(req, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeInventory(invPath)
+	results, err := newResultsFile(req.ID, "run1", req.DBType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeResultsFile(results)
+	if _, busy := activeInventories.Load(results); !busy {
+		t.Errorf("results file %s not shielded from the sweep", results)
+	}
+	copies, _ := filepath.Glob(filepath.Join(cfg.DebugInventoryDir, "*"))
+	if len(copies) != 1 {
+		t.Fatalf("debug copies = %v, want one", copies)
+	}
+	for _, f := range []string{invPath, sshKeyPath(invPath), knownHostsPath(invPath), results, copies[0]} {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&0o077 != 0 {
+			t.Errorf("%s has mode %04o, want no group/other bits", filepath.Base(f), perm)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes withUmask callers: the umask is process-wide.
+var umaskMu sync.Mutex
+
+// withUmask runs create with FILE_UMASK in effect and restores the previous umask.
+func withUmask(create func() error) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(int(cfg.FileUmask))
+	defer syscall.Umask(old)
+	return create()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// vaultValueRe is an ansible-vault encrypted string as `ansible-vault encrypt_string`
+// prints it: the $ANSIBLE_VAULT header line, then lines of hex.
+var vaultValueRe = regexp.MustCompile(`^\$ANSIBLE_VAULT;1\.[12];AES256(;[A-Za-z0-9_.-]+)?\n[0-9a-f\n]+$`)
+
+// isVaultValue reports whether a request value is vault-encrypted. ansible decrypts
+// it with the vault password; the worker never does.
+func isVaultValue(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "$ANSIBLE_VAULT;")
+}
+
+// vaultFields are the request values that may be vault-encrypted, by field name.
+func (r InstallRequest) vaultFields() map[string]string {
+	fields := map[string]string{}
+	for name, v := range map[string]string{"vm_password": r.VMPassword, "become_password": r.BecomePassword, "db_password": r.DBPassword} {
+		if isVaultValue(v) {
+			fields[name] = v
+		}
+	}
+	for k, v := range r.ExtraVars {
+		if isVaultValue(v) {
+			fields["extra_vars "+k] = v
+		}
+	}
+	return fields
+}
+
+// validateVault checks the vault-encrypted values of a request and that ansible
+// will have a password to decrypt them with.
+func validateVault(r InstallRequest) error {
+	if strings.ContainsAny(r.VaultPassword, "\r\n\x00") {
+		return errors.New("vault_password must not contain line breaks or NUL")
+	}
+	fields := r.vaultFields()
+	if len(fields) == 0 {
+		return nil
+	}
+	inInventory := false
+	for name, v := range fields {
+		if !vaultValueRe.MatchString(strings.TrimSpace(v)) {
+			return fmt.Errorf("%s is not a valid ansible-vault encrypted string", name)
+		}
+		inInventory = inInventory || !strings.HasPrefix(name, "extra_vars ")
+	}
+	if r.VaultPassword == "" && cfg.VaultPasswordFile == "" {
+		return errors.New("vault-encrypted values need vault_password or ANSIBLE_VAULT_PASSWORD_FILE")
+	}
+	// an INI host line can't hold the multi-line value
+	if inInventory && cfg.InventoryFormat != "yaml" {
+		return errors.New("vault-encrypted passwords need INVENTORY_FORMAT=yaml")
+	}
+	return nil
+}
+
+// vaultYAML renders a vault-encrypted value as a tagged YAML block scalar, its
+// lines indented by indent.
+func vaultYAML(value, indent string) string {
+	var b strings.Builder
+	b.WriteString("!vault |\n")
+	for _, line := range strings.Split(strings.TrimSpace(value), "\n") {
+		b.WriteString(indent + line + "\n")
+	}
+	return b.String()
+}
+
+// vaultExtraVars renders the request's vault-encrypted extra vars as a YAML vars
+// file for --extra-vars @file; JSON extra vars can't carry the !vault tag.
+func (r InstallRequest) vaultExtraVars() string {
+	var names []string
+	for k, v := range r.ExtraVars {
+		if isVaultValue(v) {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ": " + vaultYAML(r.ExtraVars[k], "  "))
+	}
+	return b.String()
+}
+
+// vaultPasswordPath is where a request's vault_password is written next to the
+// inventory at invPath.
+func vaultPasswordPath(invPath string) string {
+	return strings.TrimSuffix(invPath, filepath.Ext(invPath)) + ".vault_pass"
+}
+
+// vaultVarsPath is where a request's vault-encrypted extra vars are written next to
+// the inventory at invPath.
+func vaultVarsPath(invPath string) string {
+	return strings.TrimSuffix(invPath, filepath.Ext(invPath)) + ".vault_vars.yml"
+}
+
+// vaultPasswordFileFor is the file the request's vault_password is written to, or
+// "" without one.
+func (r InstallRequest) vaultPasswordFileFor(invPath string) string {
+	if r.VaultPassword == "" {
+		return ""
+	}
+	return vaultPasswordPath(invPath)
+}
+
+// vaultVarsFileFor is the vars file for the request's vault-encrypted extra vars,
+// or "" if there are none.
+func (r InstallRequest) vaultVarsFileFor(invPath string) string {
+	if r.vaultExtraVars() == "" {
+		return ""
+	}
+	return vaultVarsPath(invPath)
+}
+
+// vaultArgs are the ansible arguments that let it decrypt the request's vault
+// values: the request's own password file, else ANSIBLE_VAULT_PASSWORD_FILE when
+// something is encrypted.
+func (r InstallRequest) vaultArgs(invPath string) []string {
+	if f := r.vaultPasswordFileFor(invPath); f != "" {
+		return []string{"--vault-password-file", f}
+	}
+	if cfg.VaultPasswordFile != "" && len(r.vaultFields()) > 0 {
+		return []string{"--vault-password-file", cfg.VaultPasswordFile}
+	}
+	return nil
+}
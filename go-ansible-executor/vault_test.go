@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testVault is a value as `ansible-vault encrypt_string` prints it.
+const testVault = "$ANSIBLE_VAULT;1.1;AES256\n6231376236353436\n3063376132643630\n"
+
+func TestValidateVault(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(*InstallRequest)
+		format   string // INVENTORY_FORMAT
+		passFile string // ANSIBLE_VAULT_PASSWORD_FILE
+		wantErr  string
+	}{
+		{name: "nothing encrypted", edit: func(*InstallRequest) {}},
+		{name: "db_password", edit: func(r *InstallRequest) { r.DBPassword, r.VaultPassword = testVault, "vpw" }, format: "yaml"},
+		{name: "worker password file", edit: func(r *InstallRequest) { r.DBPassword = testVault }, format: "yaml", passFile: "/etc/vault_pass"},
+		{name: "extra var in ini", edit: func(r *InstallRequest) { r.ExtraVars, r.VaultPassword = map[string]string{"api_key": testVault}, "vpw" }, format: "ini"},
+		{name: "no password", edit: func(r *InstallRequest) { r.DBPassword = testVault }, format: "yaml", wantErr: "need vault_password or ANSIBLE_VAULT_PASSWORD_FILE"},
+		{name: "password in ini", edit: func(r *InstallRequest) { r.VMPassword, r.VaultPassword = testVault, "vpw" }, format: "ini", wantErr: "need INVENTORY_FORMAT=yaml"},
+		{
+			name: "not hex",
+			edit: func(r *InstallRequest) {
+				r.BecomePassword, r.VaultPassword = "$ANSIBLE_VAULT;1.1;AES256\nnot-hex\n", "vpw"
+			},
+			format:  "yaml",
+			wantErr: "become_password is not a valid ansible-vault encrypted string",
+		},
+		{name: "vault_password line break", edit: func(r *InstallRequest) { r.VaultPassword = "a\nb" }, wantErr: "vault_password must not contain line breaks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(format, passFile string) { cfg.InventoryFormat, cfg.VaultPasswordFile = format, passFile }(cfg.InventoryFormat, cfg.VaultPasswordFile)
+			cfg.InventoryFormat, cfg.VaultPasswordFile = tt.format, tt.passFile
+			req := testRequest()
+			tt.edit(&req)
+			err := validateVault(req)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVaultArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(*InstallRequest)
+		passFile string
+		want     []string
+	}{
+		{name: "nothing encrypted", edit: func(*InstallRequest) {}, passFile: "/etc/vault_pass"},
+		{name: "own password", edit: func(r *InstallRequest) { r.VaultPassword = "vpw" }, passFile: "/etc/vault_pass", want: []string{"--vault-password-file", "inv/vm_7.vault_pass"}},
+		{name: "worker password file", edit: func(r *InstallRequest) { r.DBPassword = testVault }, passFile: "/etc/vault_pass", want: []string{"--vault-password-file", "/etc/vault_pass"}},
+	}
+	defer func(passFile string) { cfg.VaultPasswordFile = passFile }(cfg.VaultPasswordFile)
+	for _, tt := range tests {
+		cfg.VaultPasswordFile = tt.passFile
+		req := testRequest()
+		tt.edit(&req)
+		if got := req.vaultArgs("inv/vm_7.ini"); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: vaultArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVaultExtraVars(t *testing.T) {
+	req := testRequest()
+	req.ExtraVars = map[string]string{"plain": "x", "b_key": testVault, "a_key": testVault}
+	want := "a_key: !vault |\n  $ANSIBLE_VAULT;1.1;AES256\n  6231376236353436\n  3063376132643630\n" +
+		"b_key: !vault |\n  $ANSIBLE_VAULT;1.1;AES256\n  6231376236353436\n  3063376132643630\n"
+	if got := req.vaultExtraVars(); got != want {
+		t.Errorf("vaultExtraVars() =\n%s\nwant\n%s", got, want)
+	}
+	if got := req.vaultVarsFileFor("inv/vm_7.yml"); got != "inv/vm_7.vault_vars.yml" {
+		t.Errorf("vaultVarsFileFor() = %q", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// verifyTimeout bounds each verification playbook run (see InstallRequest.Verify).
+const verifyTimeout = 5 * time.Minute
+
+// verify runs the verification playbook of each installed db_type and records the
+// outcome in st.Verified. A failed check turns the successful st into an error
+// with VERIFY_FAILED, the check's output appended to ansible_output.
+func (ir *installRun) verify(parent context.Context, st *InstallStatus, types []string) {
+	req := ir.req
+	verified := true
+	st.Verified = &verified
+	for _, t := range types {
+		dbType, _, _ := normalizeDBType(t)
+		res := playResult{DBType: dbType}
+		res.Playbook, res.Err = verifyPlaybook(dbType)
+		if res.Err == nil {
+			res.Args = append([]string{"-i", ir.invPath, res.Playbook}, req.vaultArgs(ir.invPath)...)
+			if err := runSlots.do(parent, ir.priority, func() {
+				res.ExitCode, res.Output, res.Err = ir.runner.Run(parent, res.Playbook, res.Args, req.hostKeyEnv(), verifyTimeout, outputPrefix(req.ID))
+			}); err != nil {
+				res.Err = fmt.Errorf("cancelled while waiting for a run slot: %w", context.Cause(parent))
+			}
+			res.Output = redactSecrets(res.Output, req.secrets())
+		}
+		if status, errMsg, _ := res.outcome(); status == "error" {
+			verified = false
+			st.Status = "error"
+			if errMsg == "" {
+				errMsg = fmt.Sprintf("exit %d", res.ExitCode)
+			}
+			st.Error = fmt.Sprintf("verification failed: %s: %s", dbType, errMsg)
+			st.ErrorCode = errCodeVerifyFailed
+			st.Category = res.category()
+			st.SSHDiagnostic = res.sshDiagnostic()
+			st.AnsibleExitCode = res.ExitCode
+			st.ExitReason = exitReason(res.ExitCode, res.Output)
+			st.CommandLine = strings.TrimPrefix(st.CommandLine+" && "+res.commandLine(), " && ")
+			st.AnsibleOutput = truncate(st.AnsibleOutput+fmt.Sprintf("\n===== verify %s =====\n%s", dbType, res.Output), cfg.MaxOutputBytes)
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// playbookResults is a PlaybookRunner with a canned result per playbook file name.
+type playbookResults map[string]fakeRun
+
+func (p playbookResults) Run(ctx context.Context, playbookPath string, args, env []string, timeout time.Duration, logPrefix string) (int, []byte, error) {
+	r := p[filepath.Base(playbookPath)]
+	return r.code, []byte(r.output), r.err
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name         string
+		noPlaybook   bool
+		run          fakeRun
+		wantStatus   string
+		wantVerified bool
+		wantError    string
+	}{
+		{name: "passes", wantStatus: "success", wantVerified: true},
+		{
+			name:       "fails",
+			run:        fakeRun{code: 2, output: "FAILED! => {\"msg\": \"connection refused\"}"},
+			wantStatus: "error",
+			wantError:  "verification failed: postgresql: exit 2",
+		},
+		{
+			name:       "playbook removed",
+			noPlaybook: true,
+			wantStatus: "error",
+			wantError:  `verification failed: postgresql: no verify playbook for db_type "postgresql"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupWorker(t)
+			pb := strings.TrimSuffix((*playbookAllowlist.Load())["postgresql"], ".yml") + "_verify.yml"
+			if !tt.noPlaybook {
+				if err := os.WriteFile(pb, []byte("- hosts: all\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			req := testRequest()
+			req.Verify = true
+			ir := installRun{req: req, invPath: "inventories/vm_7.ini", runner: playbookResults{"postgresql_verify.yml": tt.run}}
+			st := InstallStatus{Status: "success"}
+
+			ir.verify(context.Background(), &st, req.dbTypes())
+
+			if st.Status != tt.wantStatus || !strings.HasPrefix(st.Error, tt.wantError) {
+				t.Errorf("status = %q, error %q; want %q, %q", st.Status, st.Error, tt.wantStatus, tt.wantError)
+			}
+			if st.Verified == nil || *st.Verified != tt.wantVerified {
+				t.Errorf("verified = %v, want %v", st.Verified, tt.wantVerified)
+			}
+			if wantCode := map[bool]string{false: errCodeVerifyFailed}[tt.wantVerified]; st.ErrorCode != wantCode {
+				t.Errorf("error_code = %q, want %q", st.ErrorCode, wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	wsClientBuffer = 64 // statuses queued per client; a slow client drops the excess
+	wsWriteTimeout = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{CheckOrigin: checkWSOrigin}
+
+// checkWSOrigin admits clients without an Origin header (not a browser), pages
+// served from the relay's own host and the WS_ALLOWED_ORIGINS. Any other page
+// could otherwise read every status through its visitors' browsers.
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(cfg.WSAllowedOrigins, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), origin)
+	})
+}
+
+// wsClient is one connected browser, optionally watching a single request ID.
+type wsClient struct {
+	id   int // 0 = every request
+	send chan []byte
+}
+
+// statusBridge relays db.install.status messages to WebSocket clients.
+type statusBridge struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+}
+
+// startStatusBridge serves the WebSocket relay on addr until ctx is done.
+func startStatusBridge(ctx context.Context, nc *nats.Conn, addr string) error {
+	b := &statusBridge{clients: map[*wsClient]struct{}{}}
+
+	sub, err := nc.Subscribe(cfg.SubjectStatus, b.broadcast)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", b.serve)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("websocket bridge failed", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		b.closeAll()
+	}()
+
+	slog.Info("websocket status bridge listening", "addr", addr, "path", "/ws")
+	return nil
+}
+
+// broadcast fans a status out to every matching client without blocking on any of them.
+func (b *statusBridge) broadcast(msg *nats.Msg) {
+	var st struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Data, &st); err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if c.id != 0 && c.id != st.ID {
+			continue
+		}
+		select {
+		case c.send <- msg.Data:
+		default:
+			slog.Warn("websocket client too slow, dropping status", "id", st.ID)
+		}
+	}
+}
+
+// serve upgrades a request to /ws[?id=N] and streams statuses until the client goes away.
+func (b *statusBridge) serve(w http.ResponseWriter, r *http.Request) {
+	c := &wsClient{send: make(chan []byte, wsClientBuffer)}
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		c.id = id
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+
+	b.mu.Lock()
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+	defer b.remove(c)
+
+	// We never expect client messages; reading is how a disconnect is noticed.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+func (b *statusBridge) remove(c *wsClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c.send)
+	}
+}
+
+// closeAll tells every client the bridge is going away.
+func (b *statusBridge) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c.send)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckWSOrigin(t *testing.T) {
+	defer func(o []string) { cfg.WSAllowedOrigins = o }(cfg.WSAllowedOrigins)
+	cfg.WSAllowedOrigins = []string{"https://dashboard.example.com", "http://localhost:3000/"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "", want: true}, // not a browser
+		{origin: "http://worker1:8081", want: true},
+		{origin: "https://WORKER1:8081", want: true},
+		{origin: "https://dashboard.example.com", want: true},
+		{origin: "HTTPS://Dashboard.example.com", want: true},
+		{origin: "http://localhost:3000", want: true},
+		{origin: "http://dashboard.example.com", want: false}, // other scheme
+		{origin: "https://dashboard.example.com:8443", want: false},
+		{origin: "https://evil.example.com", want: false},
+		{origin: "http://worker1:8082", want: false},
+		{origin: "null", want: false},
+		{origin: "%zz", want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://worker1:8081/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkWSOrigin(r); got != tt.want {
+			t.Errorf("checkWSOrigin(Origin %q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+# Records every host result and the final stats as JSON lines for
+# go-ansible-executor, which sets EXECUTOR_RESULTS_FILE to a per-run file and
+# enables the plugin with ANSIBLE_CALLBACKS_ENABLED. The lines have the shape of
+# ansible.posix.jsonl events, so the worker parses either. The human-readable
+# stdout callback is left alone; without the env var this does nothing.
+from __future__ import annotations
+
+import json
+import os
+
+from ansible.plugins.callback import CallbackBase
+
+DOCUMENTATION = """
+    name: executor_results
+    type: aggregate
+    short_description: write task results as JSON lines for go-ansible-executor
+    description:
+      - Appends one JSON object per host result, and one with the stats at the end
+        of the run, to the file named by EXECUTOR_RESULTS_FILE.
+    requirements:
+      - enable in ANSIBLE_CALLBACKS_ENABLED
+"""
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = "aggregate"
+    CALLBACK_NAME = "executor_results"
+    CALLBACK_NEEDS_ENABLED = True
+
+    def __init__(self):
+        super().__init__()
+        self._path = os.environ.get("EXECUTOR_RESULTS_FILE")
+
+    def _write(self, event):
+        if not self._path:
+            return
+        # one write per line, so a run killed mid-way still leaves parsable lines
+        with open(self._path, "a", encoding="utf-8") as f:
+            f.write(json.dumps(event) + "\n")
+
+    def _record(self, event, result, **extra):
+        # _dump_results drops ansible's internal keys and honours no_log
+        res = json.loads(self._dump_results(result._result))
+        res.update(extra)
+        self._write({
+            "_event": event,
+            "task": {"id": result._task._uuid, "name": result._task.get_name()},
+            "hosts": {result._host.get_name(): res},
+        })
+
+    def v2_runner_on_ok(self, result):
+        self._record("v2_runner_on_ok", result)
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        if ignore_errors:
+            self._record("v2_runner_on_failed", result, ignored=True)
+        else:
+            self._record("v2_runner_on_failed", result)
+
+    def v2_runner_on_unreachable(self, result):
+        self._record("v2_runner_on_unreachable", result, unreachable=True)
+
+    def v2_runner_on_skipped(self, result):
+        self._record("v2_runner_on_skipped", result, skipped=True)
+
+    def v2_playbook_on_stats(self, stats):
+        self._write({
+            "_event": "v2_playbook_on_stats",
+            "stats": {h: stats.summarize(h) for h in sorted(stats.processed)},
+        })